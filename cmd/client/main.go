@@ -2,38 +2,63 @@ package main
 
 import (
 	"bytes"
+	"crypto/rand"
 	"encoding/binary"
+	"errors"
 	"flag"
 	"fmt"
 	"gameframework/pkg/proto"
 	"gameframework/pkg/reliable"
 	"image/color"
+	"math"
 	"net"
 	"sync"
 	"time"
 
+	"ballbattle/internal/channel"
+	"ballbattle/internal/fragment"
+	"ballbattle/internal/game"
+	"ballbattle/internal/snapshot"
+
 	"github.com/hajimehoshi/ebiten/v2"
 	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
 	"github.com/hajimehoshi/ebiten/v2/vector"
 )
 
-// 输入常量
-const (
-	InputNone  = 0
-	InputLeft  = 1
-	InputRight = 2
-	InputUp    = 3
-	InputDown  = 4
-)
-
-// 玩家数据
+// 玩家数据（一个玩家可以拥有多个球，死亡时没有球）
 type Player struct {
-	ID     uint16
+	ID        uint16
+	Team      uint8 // 团队模式下的队伍，否则为 game.NoTeam
+	Cells     []*Cell
+	Status    game.PlayerStatus
+	KilledBy  uint16                    // 最近一次死亡时吃掉我的玩家
+	DiedAt    uint32                    // 最近一次死亡的 tick
+	RespawnIn uint16                    // 还需等待多少 tick 才能复活
+	Effects   [game.PowerUpKinds]uint16 // 各道具效果的剩余 tick，按 game.PowerUpKind 索引
+}
+
+// 单个球
+type Cell struct {
+	ID     uint32
 	X      float32
 	Y      float32
 	Radius float32
 }
 
+// Centroid 返回玩家所有球的中心点
+func (p *Player) Centroid() (x, y float32) {
+	if len(p.Cells) == 0 {
+		return 0, 0
+	}
+	for _, c := range p.Cells {
+		x += c.X
+		y += c.Y
+	}
+	n := float32(len(p.Cells))
+	return x / n, y / n
+}
+
 // 食物数据
 type Food struct {
 	ID     uint32
@@ -41,20 +66,61 @@ type Food struct {
 	Y      float32
 	Value  float32
 	Radius float32
+	Owner  uint16 // 吐出该食物的玩家，世界食物为 game.NoOwner
+}
+
+// 病毒数据（带刺的障碍物，小球可以躲在后面，大球碰到会爆开）
+type Virus struct {
+	ID     uint32
+	X      float32
+	Y      float32
+	Radius float32
+}
+
+// 道具数据
+type PowerUp struct {
+	ID   uint32
+	Kind game.PowerUpKind
+	X    float32
+	Y    float32
 }
 
 // 游戏状态
 type GameState struct {
-	mu      sync.RWMutex
-	Players map[uint16]*Player
-	Foods   map[uint32]*Food
-	MyID    uint16
+	mu       sync.RWMutex
+	Players  map[uint16]*Player
+	Foods    map[uint32]*Food
+	Viruses  map[uint32]*Virus
+	PowerUps map[uint32]*PowerUp
+	Round    game.RoundInfo
+	MyID     uint16
+
+	// 地图几何（加入后经快照通道的事件收到一次），Arena 为 nil 表示尚未收到
+	ArenaHalf float32
+	Arena     *game.ArenaMap
+
+	// 大逃杀安全区（以原点为圆心），半径为 0 表示没有安全区
+	ZoneRadius   float32
+	ZoneShrinkIn uint32
+
+	// 团队模式下各队总质量（按队伍编号减一索引），由服务器计算，
+	// 因为快照只包含视野附近的球
+	TeamMasses []float32
+
+	// 回合事件公告（快照通道上的事件），显示到 announceUntil
+	announce      string
+	announceUntil time.Time
+
+	// 服务器的快照格式版本与客户端不一致时的提示，非空时一直显示
+	incompatible string
 }
 
 func NewGameState() *GameState {
 	return &GameState{
-		Players: make(map[uint16]*Player),
-		Foods:   make(map[uint32]*Food),
+		Players:  make(map[uint16]*Player),
+		Foods:    make(map[uint32]*Food),
+		Viruses:  make(map[uint32]*Virus),
+		PowerUps: make(map[uint32]*PowerUp),
 	}
 }
 
@@ -71,31 +137,89 @@ type Client struct {
 	joined    bool
 
 	// 输入相关
-	currentInput uint32
-	inputMu      sync.Mutex
-	localTick    uint32
+	moveX, moveY   float32 // 当前移动方向，长度不超过 1
+	pendingActions uint32  // 分裂、吐球是一次性动作，只随下一个输入发送一次
+	inputMu        sync.Mutex
+	localTick      uint32
+
+	// 快照通道（见 internal/server/snapshots.go）：服务器在这里发送只属于本客户端的快照，
+	// 客户端在 UDP 头部的 ack/ackBits 中确认收到的快照
+	snapConn   *net.UDPConn
+	snapAddr   *net.UDPAddr
+	snapFrames *fragment.Reassembler // 快照分片重组，只在快照循环中访问
+
+	// 快照解码器：保存最近的快照作为增量基线，并生成发给服务器的快照确认，
+	// 服务器据此选择增量快照的基线
+	snapMu     sync.Mutex
+	snapshots  *snapshot.Decoder
+	snapSeq    uint16
+	lastSnap   uint32
+	published  bool
+	viaChannel bool      // 已经从快照通道收到过快照
+	lastViaCh  time.Time // 最近一次从快照通道收到快照的时间
+	// 快照通道上的事件流（见 internal/channel）：当前流和按顺序收到的最后一个事件
+	eventStream uint32
+	eventSeq    uint32
+
+	// 快照通道令牌：经框架的主连接登记，订阅包必须带着它（见 internal/channel）
+	token uint64
 }
 
-func NewClient(id uint16, serverAddr string) (*Client, error) {
+// frameTimeout 是等待一帧剩余分片的最长时间，超时的帧被丢弃
+const frameTimeout = 500 * time.Millisecond
+
+const (
+	// snapshotAckInterval 是没有新快照时重发订阅和确认的间隔，必须明显短于服务器的订阅超时
+	snapshotAckInterval = 500 * time.Millisecond
+	// tokenResendAfter 是多久没有从快照通道收到快照后重新登记令牌
+	// （服务器还没有收到令牌，或者玩家超时后重新加入）
+	tokenResendAfter = time.Second
+	// tokenSeq 是登记令牌的可靠消息序号。每次重发都用同一个序号，服务器的可靠层只处理一次
+	tokenSeq = 1
+)
+
+// NewClient 创建客户端，snapshotAddr 为空时快照通道使用服务器端口加一
+func NewClient(id uint16, serverAddr, snapshotAddr string) (*Client, error) {
 	addr, err := net.ResolveUDPAddr("udp", serverAddr)
 	if err != nil {
 		return nil, err
 	}
+	snapAddr := &net.UDPAddr{IP: addr.IP, Port: addr.Port + 1, Zone: addr.Zone}
+	if snapshotAddr != "" {
+		if snapAddr, err = net.ResolveUDPAddr("udp", snapshotAddr); err != nil {
+			return nil, err
+		}
+	}
 
 	conn, err := net.ListenUDP("udp", nil)
 	if err != nil {
 		return nil, err
 	}
+	snapConn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	var token [channel.TokenSize]byte
+	if _, err := rand.Read(token[:]); err != nil {
+		conn.Close()
+		snapConn.Close()
+		return nil, err
+	}
 
 	c := &Client{
-		id:           id,
-		conn:         conn,
-		serverAddr:   addr,
-		rxReliable:   reliable.NewReliableReceiver(),
-		txReliable:   reliable.NewReliableSender(),
-		gameState:    NewGameState(),
-		currentInput: InputNone,
-		joined:       true, // 直接允许发送输入，服务端收到输入时注册玩家
+		id:         id,
+		conn:       conn,
+		serverAddr: addr,
+		rxReliable: reliable.NewReliableReceiver(),
+		txReliable: reliable.NewReliableSender(),
+		gameState:  NewGameState(),
+		joined:     true, // 直接允许发送输入，服务端收到输入时注册玩家
+		snapConn:   snapConn,
+		snapAddr:   snapAddr,
+		snapFrames: fragment.NewReassembler(frameTimeout),
+		snapshots:  snapshot.NewDecoder(),
+		token:      binary.LittleEndian.Uint64(token[:]),
 	}
 	c.gameState.MyID = id
 
@@ -127,7 +251,8 @@ func (c *Client) SendInput(tick uint32, input uint32) error {
 
 // 接收循环
 func (c *Client) RecvLoop() {
-	buf := make([]byte, 4096)
+	// 单个 UDP 数据报最大约 64KB，按最大值分配，避免超长数据包被截断
+	buf := make([]byte, 64*1024)
 	fmt.Println("📡 开始接收循环...")
 	for {
 		n, raddr, err := c.conn.ReadFromUDP(buf)
@@ -161,10 +286,10 @@ func (c *Client) RecvLoop() {
 			if tick > c.localTick {
 				c.localTick = tick
 			}
-			// fmt.Printf("✓ 成功解析帧数据包: tick=%d, inputs=%d\n", tick, len(inputs))
 			// 读取快照数据
 			r := bytes.NewReader(payload)
-			// 跳过已读的帧数据
+			// 跳过已读的帧数据。输入数是框架定义的 uint8，超过 255 个玩家时会溢出，
+			// 之后的快照长度读错，所以关闭快照通道的服务器最多支持 255 个玩家
 			var tempTick uint32
 			var tempCount uint8
 			binary.Read(r, binary.LittleEndian, &tempTick)
@@ -183,58 +308,21 @@ func (c *Client) RecvLoop() {
 				fmt.Printf("⚠ 没有快照数据 (err: %v)\n", err)
 				continue
 			}
-			if snapLen == 0 {
-				fmt.Printf("⚠ 快照长度为0\n")
+			c.joined = true
+			// 快照通道开启时帧里没有快照；收到过快照通道的快照后也不再使用帧里的快照，
+			// 否则两路快照会混用同一个解码器的基线
+			if snapLen == 0 || c.usingSnapshotChannel() {
+				continue
+			}
+			data := payload[len(payload)-r.Len():]
+			if len(data) < int(snapLen) {
+				fmt.Printf("⚠ 快照数据不完整: %d/%d bytes\n", len(data), snapLen)
 				continue
 			}
 			fmt.Printf("📦 快照长度: %d bytes\n", snapLen)
-			c.joined = true
-
-			// 读取玩家数据
-			var playerCount uint8
-			if err := binary.Read(r, binary.LittleEndian, &playerCount); err == nil {
-				c.gameState.mu.Lock()
-				fmt.Printf("📦 解析到 %d 个玩家\n", playerCount)
-				for i := 0; i < int(playerCount); i++ {
-					var p Player
-					binary.Read(r, binary.LittleEndian, &p.ID)
-					binary.Read(r, binary.LittleEndian, &p.X)
-					binary.Read(r, binary.LittleEndian, &p.Y)
-					binary.Read(r, binary.LittleEndian, &p.Radius)
-					c.gameState.Players[p.ID] = &p
-					if p.ID == c.gameState.MyID {
-						fmt.Printf("✓ 收到我的玩家数据: ID=%d, pos=(%.1f, %.1f), radius=%.2f\n",
-							p.ID, p.X, p.Y, p.Radius)
-					} else {
-						fmt.Printf("  - 玩家 %d: pos=(%.1f, %.1f), radius=%.2f\n",
-							p.ID, p.X, p.Y, p.Radius)
-					}
-				}
 
-				// 读取食物数据
-				var foodCount uint16
-				if err := binary.Read(r, binary.LittleEndian, &foodCount); err == nil {
-					c.gameState.Foods = make(map[uint32]*Food)
-					fmt.Printf("📦 解析到 %d 个食物\n", foodCount)
-					for i := 0; i < int(foodCount); i++ {
-						var f Food
-						binary.Read(r, binary.LittleEndian, &f.ID)
-						binary.Read(r, binary.LittleEndian, &f.X)
-						binary.Read(r, binary.LittleEndian, &f.Y)
-						binary.Read(r, binary.LittleEndian, &f.Value)
-						binary.Read(r, binary.LittleEndian, &f.Radius)
-						c.gameState.Foods[f.ID] = &f
-					}
-					if foodCount > 0 && len(c.gameState.Players) > 0 {
-						fmt.Printf("✓ 收到完整游戏数据: %d 玩家, %d 食物\n",
-							len(c.gameState.Players), int(foodCount))
-					}
-				} else {
-					fmt.Printf("⚠ 读取食物数据失败: %v\n", err)
-				}
-				c.gameState.mu.Unlock()
-			} else {
-				fmt.Printf("⚠ 读取玩家数据失败: %v\n", err)
+			if err := c.applySnapshot(tick, data[:snapLen]); err != nil {
+				fmt.Printf("⚠ 解析快照失败: %v\n", err)
 			}
 		} else if rseq, inner, err2 := proto.UnpackReliableEnvelope(payload); err2 == nil {
 			// 仅处理 Ping/Pong 等通用可靠消息
@@ -246,9 +334,268 @@ func (c *Client) RecvLoop() {
 				}
 			}
 		} else if len(payload) > 4 {
-			fmt.Printf("⚠ 未知数据包: 帧解析err=%v, 可靠解析err=%v, payload len=%d, first 4 bytes: %x\n",
-				err, err2, len(payload), payload[:4])
+			fmt.Printf("⚠ 未知数据包: 可靠解析err=%v, payload len=%d, first 4 bytes: %x\n",
+				err2, len(payload), payload[:4])
+		}
+	}
+}
+
+// handleRoundEvent 处理回合阶段变化的事件，并显示公告
+func (c *Client) handleRoundEvent(payload []byte) {
+	info, err := game.DecodeRoundEvent(payload)
+	if err != nil {
+		fmt.Printf("⚠ 解析回合事件失败: %v\n", err)
+		return
+	}
+	var msg string
+	switch info.Phase {
+	case game.PhaseWarmup:
+		msg = fmt.Sprintf("第 %d 回合即将开始", info.Round)
+	case game.PhaseRunning:
+		msg = fmt.Sprintf("第 %d 回合开始！", info.Round)
+	case game.PhaseEnded:
+		if info.Winner == game.NoOwner {
+			msg = fmt.Sprintf("第 %d 回合结束，没有胜者", info.Round)
+		} else if info.WinnerTeam != game.NoTeam {
+			msg = fmt.Sprintf("第 %d 回合结束，胜者：%d 队（总质量 %.1f，MVP 玩家 %d）", info.Round, info.WinnerTeam, info.WinnerMass, info.Winner)
+		} else {
+			msg = fmt.Sprintf("第 %d 回合结束，胜者：玩家 %d（质量 %.1f）", info.Round, info.Winner, info.WinnerMass)
+		}
+	case game.PhaseIntermission:
+		msg = "休息时间，玩家之间不能互相吞噬"
+	}
+	fmt.Printf("🏁 %s\n", msg)
+
+	c.gameState.mu.Lock()
+	c.gameState.Round = info
+	c.gameState.announce = msg
+	c.gameState.announceUntil = time.Now().Add(3 * time.Second)
+	c.gameState.mu.Unlock()
+}
+
+// handleMapGeometry 保存服务器发来的地图几何
+func (c *Client) handleMapGeometry(payload []byte) {
+	arenaHalf, arena, err := game.DecodeMap(payload)
+	if err != nil {
+		fmt.Printf("⚠ 解析地图失败: %v\n", err)
+		return
+	}
+	fmt.Printf("🗺  收到地图: 半边长 %.0f，%d 个圆形障碍，%d 个矩形障碍，%d 面墙\n",
+		arenaHalf, len(arena.Circles), len(arena.Rects), len(arena.Walls))
+	c.gameState.mu.Lock()
+	c.gameState.ArenaHalf = arenaHalf
+	c.gameState.Arena = arena
+	c.gameState.mu.Unlock()
+}
+
+// applySnapshot 解码完整快照或增量快照（由 snapshot.Decoder 应用到基线上并确认收到），
+// 并在它是最新快照时替换本地游戏状态
+func (c *Client) applySnapshot(tick uint32, data []byte) error {
+	c.snapMu.Lock()
+	defer c.snapMu.Unlock()
+	w, err := c.snapshots.Decode(tick, data)
+	var verr *snapshot.VersionError
+	if errors.As(err, &verr) {
+		// 版本不一致时每个快照都会失败，只提示一次
+		c.gameState.mu.Lock()
+		first := c.gameState.incompatible == ""
+		c.gameState.incompatible = fmt.Sprintf("服务器快照格式版本 %d 与客户端（版本 %d）不兼容，请更新", verr.Got, snapshot.Version)
+		c.gameState.mu.Unlock()
+		if first {
+			return err
 		}
+		return nil
+	}
+	if err != nil {
+		// 缺少基线时不确认这个快照，服务器会改用更早确认的基线或发送完整快照
+		return err
+	}
+	// 解码成功说明版本已经一致（例如服务器更新后重启），去掉不兼容的提示
+	c.gameState.mu.Lock()
+	c.gameState.incompatible = ""
+	c.gameState.mu.Unlock()
+
+	// 乱序到达的旧快照只作为基线，不覆盖更新的状态
+	if c.published && tick <= c.lastSnap {
+		return nil
+	}
+	c.published, c.lastSnap = true, tick
+
+	// 转换为本地游戏状态，球按玩家组装
+	players := make(map[uint16]*Player, len(w.Players))
+	for _, sp := range w.Players {
+		p := &Player{
+			ID:        sp.ID,
+			Team:      sp.Team,
+			Status:    game.PlayerStatus(sp.Status),
+			KilledBy:  sp.KilledBy,
+			DiedAt:    sp.DiedAt,
+			RespawnIn: sp.RespawnIn,
+			Effects:   sp.Effects,
+		}
+		players[p.ID] = p
+	}
+	for _, sc := range w.Cells {
+		p := players[sc.Owner]
+		if p == nil {
+			p = &Player{ID: sc.Owner}
+			players[sc.Owner] = p
+		}
+		p.Cells = append(p.Cells, &Cell{ID: sc.ID, X: sc.X, Y: sc.Y, Radius: sc.Radius})
+	}
+	foods := make(map[uint32]*Food, len(w.Foods))
+	for _, f := range w.Foods {
+		foods[f.ID] = &Food{ID: f.ID, X: f.X, Y: f.Y, Value: f.Value, Radius: f.Radius, Owner: f.Owner}
+	}
+	viruses := make(map[uint32]*Virus, len(w.Viruses))
+	for _, v := range w.Viruses {
+		viruses[v.ID] = &Virus{ID: v.ID, X: v.X, Y: v.Y, Radius: v.Radius}
+	}
+	powerUps := make(map[uint32]*PowerUp, len(w.PowerUps))
+	for _, pu := range w.PowerUps {
+		powerUps[pu.ID] = &PowerUp{ID: pu.ID, Kind: game.PowerUpKind(pu.Kind), X: pu.X, Y: pu.Y}
+	}
+
+	c.gameState.mu.Lock()
+	c.gameState.Round = game.RoundInfo{
+		Phase:      game.RoundPhase(w.Round.Phase),
+		Round:      w.Round.Number,
+		Remaining:  w.Round.Remaining,
+		Winner:     w.Round.Winner,
+		WinnerTeam: w.Round.WinnerTeam,
+		WinnerMass: w.Round.WinnerMass,
+	}
+	c.gameState.ZoneRadius = w.ZoneRadius
+	c.gameState.ZoneShrinkIn = w.ZoneShrinkIn
+	c.gameState.TeamMasses = w.TeamMasses
+	c.gameState.Players = players
+	c.gameState.Foods = foods
+	c.gameState.Viruses = viruses
+	c.gameState.PowerUps = powerUps
+	if me := players[c.gameState.MyID]; me != nil {
+		x, y := me.Centroid()
+		fmt.Printf("✓ 收到我的玩家数据: ID=%d, cells=%d, center=(%.1f, %.1f)\n",
+			me.ID, len(me.Cells), x, y)
+	}
+	c.gameState.mu.Unlock()
+	if data[1] == snapshot.KindDelta {
+		fmt.Printf("✓ 应用增量快照: %d 玩家, %d 食物, %d 病毒\n", len(players), len(foods), len(viruses))
+	} else {
+		fmt.Printf("✓ 收到完整游戏数据: %d 玩家, %d 食物, %d 病毒\n", len(players), len(foods), len(viruses))
+	}
+	return nil
+}
+
+// SnapshotLoop 接收快照通道上的快照分片，每解码一个快照就把新的确认发给服务器
+func (c *Client) SnapshotLoop() {
+	buf := make([]byte, 64*1024)
+	for {
+		n, raddr, err := c.snapConn.ReadFromUDP(buf)
+		if err != nil {
+			fmt.Printf("⚠ 快照通道接收错误: %v\n", err)
+			continue
+		}
+		if raddr.String() != c.snapAddr.String() {
+			continue
+		}
+		_, _, _, payload, err := proto.ReadUDPHeader(buf[:n])
+		if err != nil {
+			continue
+		}
+		if ev, ok := channel.ParseEvent(payload); ok {
+			c.handleEvent(ev)
+			continue
+		}
+		frag, ok := fragment.Parse(payload)
+		if !ok {
+			continue
+		}
+		data, done := c.snapFrames.Add(frag, time.Now())
+		if !done || len(data) == 0 {
+			continue
+		}
+		fmt.Printf("📦 快照长度: %d bytes (%d 个分片)\n", len(data), frag.Count)
+		c.snapMu.Lock()
+		c.viaChannel, c.lastViaCh = true, time.Now()
+		c.snapMu.Unlock()
+		if err := c.applySnapshot(frag.Tick, data); err != nil {
+			fmt.Printf("⚠ 解析快照失败: %v\n", err)
+			continue
+		}
+		c.sendSnapshotAck()
+	}
+}
+
+// handleEvent 处理快照通道上的事件：只接受当前流的下一个事件，或新的流的第一个事件，
+// 其余（重发的、乱序的）丢弃。不论是否接受都回复确认，服务器据此停止重发
+func (c *Client) handleEvent(ev channel.Event) {
+	c.snapMu.Lock()
+	next := ev.Stream == c.eventStream && ev.Seq == c.eventSeq+1
+	restart := ev.Stream != c.eventStream && ev.Seq == 1
+	if next || restart {
+		c.eventStream, c.eventSeq = ev.Stream, ev.Seq
+	}
+	c.snapMu.Unlock()
+
+	if next || restart {
+		switch ev.Payload[0] {
+		case game.MsgMapGeometry:
+			c.handleMapGeometry(ev.Payload)
+		case game.MsgRoundEvent:
+			c.handleRoundEvent(ev.Payload)
+		}
+	}
+	c.sendSnapshotAck()
+}
+
+// usingSnapshotChannel 报告是否已经从快照通道收到过快照
+func (c *Client) usingSnapshotChannel() bool {
+	c.snapMu.Lock()
+	defer c.snapMu.Unlock()
+	return c.viaChannel
+}
+
+// SnapshotSubscribeLoop 定期向快照通道发送订阅和最新的确认，
+// 使服务器在还没有收到快照或确认包丢失时仍然知道往哪里发送、以哪个快照为基线；
+// 一段时间没有收到快照时还经主连接重新登记令牌
+func (c *Client) SnapshotSubscribeLoop() {
+	ticker := time.NewTicker(snapshotAckInterval)
+	for range ticker.C {
+		c.snapMu.Lock()
+		stale := time.Since(c.lastViaCh) > tokenResendAfter
+		c.snapMu.Unlock()
+		if stale {
+			c.sendToken()
+		}
+		c.sendSnapshotAck()
+	}
+}
+
+// sendToken 经框架的主连接用可靠消息登记快照通道令牌，服务器据此确认订阅包来自这个玩家
+func (c *Client) sendToken() {
+	ack, ackbits := c.rxReliable.BuildAckAndBits()
+	buf := &bytes.Buffer{}
+	proto.WriteUDPHeader(buf, c.txReliable.NextPacketSeq(), ack, ackbits)
+	proto.PackReliableEnvelope(buf, tokenSeq, channel.AppendToken(nil, c.token))
+	if _, err := c.conn.WriteToUDP(buf.Bytes(), c.serverAddr); err != nil {
+		fmt.Printf("⚠ 登记快照通道令牌失败: %v\n", err)
+	}
+}
+
+// sendSnapshotAck 发送订阅包：[UDP 头部: ack/ackBits 为快照确认] [订阅包（见 internal/channel）]
+func (c *Client) sendSnapshotAck() {
+	c.snapMu.Lock()
+	ack, ackBits, ok := c.snapshots.Ack()
+	c.snapSeq++
+	seq := c.snapSeq
+	sub := channel.Subscribe{PlayerID: c.id, HasAck: ok, Token: c.token, Stream: c.eventStream, EventAck: c.eventSeq}
+	c.snapMu.Unlock()
+
+	buf := &bytes.Buffer{}
+	proto.WriteUDPHeader(buf, seq, uint16(ack), ackBits)
+	buf.Write(channel.AppendSubscribe(nil, sub))
+	if _, err := c.snapConn.WriteToUDP(buf.Bytes(), c.snapAddr); err != nil {
+		fmt.Printf("⚠ 发送快照确认失败: %v\n", err)
 	}
 }
 
@@ -275,7 +622,8 @@ func (c *Client) InputLoop(tickHz int) {
 	fmt.Println("⌨️  输入循环已启动")
 	for range ticker.C {
 		c.inputMu.Lock()
-		input := c.currentInput
+		input := game.EncodeInput(c.moveX, c.moveY, c.pendingActions)
+		c.pendingActions = 0
 		c.inputMu.Unlock()
 
 		// 发送输入时，使用当前 localTick + 1（预测下一帧，给服务器处理时间）
@@ -283,9 +631,10 @@ func (c *Client) InputLoop(tickHz int) {
 		sendTick := c.localTick + 1
 		if err := c.SendInput(sendTick, input); err != nil {
 			fmt.Printf("⚠ 发送输入失败: %v\n", err)
-		} else if input != InputNone {
-			fmt.Printf("⌨️  发送输入: tick=%d, input=%d (%s)\n", sendTick, input,
-				map[uint32]string{InputLeft: "左", InputRight: "右", InputUp: "上", InputDown: "下"}[input])
+		} else if input != game.InputNone {
+			dx, dy, actions := game.DecodeInput(input)
+			fmt.Printf("⌨️  发送输入: tick=%d, input=%#x (方向=(%.2f, %.2f), 分裂=%v, 吐球=%v)\n", sendTick, input,
+				dx, dy, actions&game.InputActionSplit != 0, actions&game.InputActionEject != 0)
 		}
 
 		c.localTick++
@@ -294,28 +643,35 @@ func (c *Client) InputLoop(tickHz int) {
 
 // 游戏结构（实现 ebiten.Game 接口）
 type Game struct {
-	client   *Client
-	screenW  int
-	screenH  int
-	cameraX  float32
-	cameraY  float32
-	scale    float32
-	debugMsg string
+	client     *Client
+	screenW    int
+	screenH    int
+	cameraX    float32
+	cameraY    float32
+	scale      float32
+	debugMsg   string
+	mouseSteer bool // 没有按键时朝鼠标方向移动
+	tickHz     int  // 服务器 tick 率，用于把 tick 换算为秒
 }
 
-func NewGame(client *Client) *Game {
+// mouseFullSpeedDist 鼠标距屏幕中心超过该像素距离时全速移动
+const mouseFullSpeedDist = 100
+
+func NewGame(client *Client, mouseSteer bool, tickHz int) *Game {
 	return &Game{
-		client:   client,
-		screenW:  800,
-		screenH:  600,
-		scale:    3.0, // 增大缩放，让物体更明显
-		debugMsg: "等待连接...",
+		client:     client,
+		mouseSteer: mouseSteer,
+		tickHz:     tickHz,
+		screenW:    800,
+		screenH:    600,
+		scale:      3.0, // 增大缩放，让物体更明显
+		debugMsg:   "等待连接...",
 	}
 }
 
 func (g *Game) Update() error {
-	// 处理键盘输入（优先级：上下 > 左右）
-	var input uint32 = InputNone
+	// 处理键盘输入：同时按下的方向键合成为斜向移动
+	var dx, dy float32
 
 	// 检查所有可能的按键
 	upPressed := ebiten.IsKeyPressed(ebiten.KeyArrowUp) || ebiten.IsKeyPressed(ebiten.KeyW)
@@ -327,34 +683,55 @@ func (g *Game) Update() error {
 	anyKeyPressed := upPressed || downPressed || leftPressed || rightPressed
 
 	if upPressed {
-		input = InputUp
-	} else if downPressed {
-		input = InputDown
-	} else if leftPressed {
-		input = InputLeft
-	} else if rightPressed {
-		input = InputRight
+		dy++
+	}
+	if downPressed {
+		dy--
+	}
+	if leftPressed {
+		dx--
+	}
+	if rightPressed {
+		dx++
+	}
+
+	// 没有按键时朝鼠标方向移动，离屏幕中心越远速度越快
+	if !anyKeyPressed && g.mouseSteer {
+		mx, my := ebiten.CursorPosition()
+		dx = float32(mx-g.screenW/2) / mouseFullSpeedDist
+		dy = -float32(my-g.screenH/2) / mouseFullSpeedDist // Y轴翻转
 	}
 
 	g.client.inputMu.Lock()
-	oldInput := g.client.currentInput
-	g.client.currentInput = input
+	changed := dx != g.client.moveX || dy != g.client.moveY
+	g.client.moveX, g.client.moveY = dx, dy
+	if inpututil.IsKeyJustPressed(ebiten.KeySpace) {
+		g.client.pendingActions |= game.InputActionSplit
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyE) {
+		g.client.pendingActions |= game.InputActionEject
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyR) {
+		g.client.pendingActions |= game.InputActionRespawn
+	}
 	g.client.inputMu.Unlock()
 
 	// 调试：打印按键状态（只在有按键按下时打印，避免刷屏）
-	if anyKeyPressed && input != oldInput {
-		fmt.Printf("🎮 按键检测: W=%v A=%v S=%v D=%v → input=%d (old=%d)\n",
-			upPressed, leftPressed, downPressed, rightPressed, input, oldInput)
+	if anyKeyPressed && changed {
+		fmt.Printf("🎮 按键检测: W=%v A=%v S=%v D=%v → 方向=(%.0f, %.0f)\n",
+			upPressed, leftPressed, downPressed, rightPressed, dx, dy)
 	}
 
-	// 更新相机位置（跟随我的玩家）
+	// 更新相机位置（跟随我的所有球的中心）
 	g.client.gameState.mu.RLock()
 	myPlayer := g.client.gameState.Players[g.client.gameState.MyID]
-	if myPlayer != nil {
-		g.cameraX = myPlayer.X
-		g.cameraY = myPlayer.Y
+	if myPlayer != nil && len(myPlayer.Cells) > 0 {
+		g.cameraX, g.cameraY = myPlayer.Centroid()
 		g.debugMsg = fmt.Sprintf("已连接 | 玩家:%d 食物:%d",
 			len(g.client.gameState.Players), len(g.client.gameState.Foods))
+	} else if myPlayer != nil && myPlayer.Status == game.StatusDead {
+		// 死亡时相机停在原地
+		g.debugMsg = "已死亡"
 	} else {
 		if g.client.joined {
 			g.debugMsg = "已加入，等待玩家数据..."
@@ -380,6 +757,11 @@ func (g *Game) Draw(screen *ebiten.Image) {
 		return
 	}
 
+	// 绘制竞技场边界和障碍物
+	if arena := g.client.gameState.Arena; arena != nil {
+		g.drawArena(screen, worldToScreen, g.client.gameState.ArenaHalf, arena)
+	}
+
 	// 绘制食物
 	for _, f := range g.client.gameState.Foods {
 		sx, sy := worldToScreen(f.X, f.Y)
@@ -387,34 +769,30 @@ func (g *Game) Draw(screen *ebiten.Image) {
 
 		// 只绘制在屏幕范围内的食物
 		if sx >= -50 && sx <= float32(g.screenW)+50 && sy >= -50 && sy <= float32(g.screenH)+50 {
-			// 绘制食物（绿色小圆）
-			vector.DrawFilledCircle(screen, float32(sx), float32(sy), radius, color.RGBA{100, 200, 100, 255}, true)
+			// 绘制食物（绿色小圆，玩家吐出的食物使用该玩家的颜色）
+			foodColor := color.RGBA{100, 200, 100, 255}
+			if f.Owner != game.NoOwner {
+				foodColor = g.client.gameState.colorFor(f.Owner)
+			}
+			vector.DrawFilledCircle(screen, float32(sx), float32(sy), radius, foodColor, true)
 		}
 	}
 
-	// 绘制玩家
+	// 绘制玩家（每个玩家的所有球）
 	for _, p := range g.client.gameState.Players {
-		sx, sy := worldToScreen(p.X, p.Y)
-		radius := p.Radius * g.scale
-
-		// 只绘制在屏幕范围内的玩家
-		if sx >= -100 && sx <= float32(g.screenW)+100 && sy >= -100 && sy <= float32(g.screenH)+100 {
-			// 所有玩家都根据 ID 使用相同的颜色算法，确保在不同客户端看到相同颜色
-			colors := []color.RGBA{
-				{100, 150, 255, 255}, // 蓝（ID 0）
-				{255, 100, 100, 255}, // 红（ID 1）
-				{255, 200, 100, 255}, // 橙（ID 2）
-				{200, 100, 255, 255}, // 紫（ID 3）
-				{100, 255, 200, 255}, // 青（ID 4）
-				{255, 100, 200, 255}, // 粉（ID 5）
-				{200, 255, 100, 255}, // 黄绿（ID 6）
-				{255, 255, 100, 255}, // 黄（ID 7）
+		playerColor := g.client.gameState.colorFor(p.ID)
+		for _, c := range p.Cells {
+			sx, sy := worldToScreen(c.X, c.Y)
+			radius := c.Radius * g.scale
+
+			// 只绘制在屏幕范围内的球
+			if sx < -100-radius || sx > float32(g.screenW)+100+radius || sy < -100-radius || sy > float32(g.screenH)+100+radius {
+				continue
 			}
-			colorIdx := int(p.ID) % len(colors)
-			playerColor := colors[colorIdx]
 
 			// 绘制玩家球
 			vector.DrawFilledCircle(screen, float32(sx), float32(sy), radius, playerColor, true)
+			drawAuras(screen, p, sx, sy, radius)
 
 			// 如果是自己的玩家，添加白色边框以区分
 			if p.ID == g.client.gameState.MyID {
@@ -423,23 +801,220 @@ func (g *Game) Draw(screen *ebiten.Image) {
 		}
 	}
 
+	// 绘制病毒（画在玩家之上，小球可以躲在病毒后面）
+	for _, v := range g.client.gameState.Viruses {
+		sx, sy := worldToScreen(v.X, v.Y)
+		radius := v.Radius * g.scale
+		if sx < -radius || sx > float32(g.screenW)+radius || sy < -radius || sy > float32(g.screenH)+radius {
+			continue
+		}
+		drawVirus(screen, sx, sy, radius)
+	}
+
+	// 绘制道具
+	for _, pu := range g.client.gameState.PowerUps {
+		sx, sy := worldToScreen(pu.X, pu.Y)
+		radius := float32(1.0) * g.scale
+		if sx < -radius || sx > float32(g.screenW)+radius || sy < -radius || sy > float32(g.screenH)+radius {
+			continue
+		}
+		vector.DrawFilledCircle(screen, sx, sy, radius, powerUpColors[pu.Kind], true)
+		vector.StrokeCircle(screen, sx, sy, radius+2, 1, color.RGBA{255, 255, 255, 255}, true)
+	}
+
+	// 绘制安全区边界
+	if g.client.gameState.ZoneRadius > 0 {
+		sx, sy := worldToScreen(0, 0)
+		vector.StrokeCircle(screen, sx, sy, g.client.gameState.ZoneRadius*g.scale, 3, color.RGBA{255, 60, 60, 255}, true)
+	}
+
 	// 绘制 UI 信息
 	myPlayer := g.client.gameState.Players[g.client.gameState.MyID]
 	if myPlayer != nil {
-		info := fmt.Sprintf("%s\nID: %d | 位置: (%.1f, %.1f) | 半径: %.2f | 相机: (%.1f, %.1f)",
+		var mass float32
+		for _, c := range myPlayer.Cells {
+			mass += c.Radius * c.Radius
+		}
+		info := fmt.Sprintf("%s\nID: %d | 球数: %d | 质量: %.1f | 相机: (%.1f, %.1f)",
 			g.debugMsg,
-			myPlayer.ID, myPlayer.X, myPlayer.Y, myPlayer.Radius,
+			myPlayer.ID, len(myPlayer.Cells), mass,
 			g.cameraX, g.cameraY)
 		ebitenutil.DebugPrint(screen, info)
 	} else {
 		ebitenutil.DebugPrint(screen, g.debugMsg)
 	}
 
+	g.drawRoundHUD(screen)
+
+	if myPlayer != nil {
+		g.drawEffectTimers(screen, myPlayer)
+	}
+
+	// 死亡界面
+	if myPlayer != nil && myPlayer.Status == game.StatusDead {
+		g.drawDeathScreen(screen, myPlayer)
+	}
+
 	// 绘制操作提示
-	controls := "方向键或 WASD 移动"
+	controls := "方向键或 WASD 移动，空格分裂，E 吐球，R 复活"
 	ebitenutil.DebugPrintAt(screen, controls, 0, g.screenH-20)
 }
 
+// drawRoundHUD 在右上角显示回合阶段和剩余时间，并在屏幕上方显示回合公告
+func (g *Game) drawRoundHUD(screen *ebiten.Image) {
+	round := g.client.gameState.Round
+	if round.Round > 0 {
+		phases := map[game.RoundPhase]string{
+			game.PhaseWarmup:       "准备",
+			game.PhaseRunning:      "进行中",
+			game.PhaseEnded:        "结算",
+			game.PhaseIntermission: "休息",
+		}
+		hud := fmt.Sprintf("第 %d 回合 %s %.0f 秒", round.Round, phases[round.Phase],
+			math.Ceil(float64(round.Remaining)/float64(g.tickHz)))
+		ebitenutil.DebugPrintAt(screen, hud, g.screenW-160, 0)
+	}
+	// 团队模式下显示各队总质量
+	for i, mass := range g.client.gameState.TeamMasses {
+		line := fmt.Sprintf("%d 队: %.1f", i+1, mass)
+		ebitenutil.DebugPrintAt(screen, line, g.screenW-160, 16*(i+1))
+	}
+	if zr := g.client.gameState.ZoneRadius; zr > 0 {
+		zone := fmt.Sprintf("安全区半径 %.0f，正在缩小", zr)
+		if in := g.client.gameState.ZoneShrinkIn; in > 0 {
+			zone = fmt.Sprintf("安全区半径 %.0f，%.0f 秒后缩小", zr, math.Ceil(float64(in)/float64(g.tickHz)))
+		}
+		ebitenutil.DebugPrintAt(screen, zone, g.screenW-220, 16)
+	}
+	if g.client.gameState.announce != "" && time.Now().Before(g.client.gameState.announceUntil) {
+		ebitenutil.DebugPrintAt(screen, g.client.gameState.announce, g.screenW/2-120, 40)
+	}
+	if msg := g.client.gameState.incompatible; msg != "" {
+		ebitenutil.DebugPrintAt(screen, msg, g.screenW/2-180, g.screenH/2)
+	}
+}
+
+// 道具颜色，光环使用相同颜色，按 game.PowerUpKind 索引
+var powerUpColors = [game.PowerUpKinds]color.RGBA{
+	game.PowerSpeed:  {255, 230, 60, 255},  // 加速：黄
+	game.PowerShield: {80, 220, 255, 255},  // 护盾：青
+	game.PowerMagnet: {220, 100, 255, 255}, // 磁铁：紫
+}
+
+var powerUpNames = [game.PowerUpKinds]string{
+	game.PowerSpeed:  "加速",
+	game.PowerShield: "护盾",
+	game.PowerMagnet: "磁铁",
+}
+
+// drawAuras 为拥有道具效果的球绘制光环，多个效果向外依次排列
+func drawAuras(screen *ebiten.Image, p *Player, sx, sy, radius float32) {
+	ring := float32(0)
+	for kind, left := range p.Effects {
+		if left == 0 {
+			continue
+		}
+		ring += 3
+		vector.StrokeCircle(screen, sx, sy, radius+ring, 2, powerUpColors[kind], true)
+	}
+}
+
+// drawEffectTimers 在左侧显示我当前道具效果的剩余秒数
+func (g *Game) drawEffectTimers(screen *ebiten.Image, me *Player) {
+	y := 40
+	for kind, left := range me.Effects {
+		if left == 0 {
+			continue
+		}
+		line := fmt.Sprintf("%s %.1f 秒", powerUpNames[kind], float32(left)/float32(g.tickHz))
+		ebitenutil.DebugPrintAt(screen, line, 0, y)
+		y += 16
+	}
+}
+
+// drawArena 绘制竞技场边界、圆形和矩形障碍物以及墙
+func (g *Game) drawArena(screen *ebiten.Image, worldToScreen func(wx, wy float32) (float32, float32), half float32, arena *game.ArenaMap) {
+	obstColor := color.RGBA{110, 110, 130, 255}
+	x0, y0 := worldToScreen(-half, half)
+	vector.StrokeRect(screen, x0, y0, 2*half*g.scale, 2*half*g.scale, 2, color.RGBA{80, 80, 100, 255}, false)
+	for _, o := range arena.Circles {
+		sx, sy := worldToScreen(o.X, o.Y)
+		vector.DrawFilledCircle(screen, sx, sy, o.Radius*g.scale, obstColor, true)
+	}
+	for _, b := range arena.Rects {
+		sx, sy := worldToScreen(b.X-b.HalfW, b.Y+b.HalfH) // 左上角（屏幕 Y 向下）
+		vector.DrawFilledRect(screen, sx, sy, 2*b.HalfW*g.scale, 2*b.HalfH*g.scale, obstColor, false)
+	}
+	for _, w := range arena.Walls {
+		sx1, sy1 := worldToScreen(w.X1, w.Y1)
+		sx2, sy2 := worldToScreen(w.X2, w.Y2)
+		width := w.Thickness * g.scale
+		vector.StrokeLine(screen, sx1, sy1, sx2, sy2, width, obstColor, true)
+		// 墙是胶囊形，两端补上半圆
+		vector.DrawFilledCircle(screen, sx1, sy1, width/2, obstColor, true)
+		vector.DrawFilledCircle(screen, sx2, sy2, width/2, obstColor, true)
+	}
+}
+
+// drawDeathScreen 绘制半透明遮罩、击杀者和复活倒计时
+func (g *Game) drawDeathScreen(screen *ebiten.Image, me *Player) {
+	vector.DrawFilledRect(screen, 0, 0, float32(g.screenW), float32(g.screenH), color.RGBA{0, 0, 0, 160}, false)
+	msg := fmt.Sprintf("你被玩家 %d 吃掉了（tick %d）\n\n", me.KilledBy, me.DiedAt)
+	if me.KilledBy == game.NoOwner {
+		msg = fmt.Sprintf("你已被淘汰（tick %d）\n\n", me.DiedAt)
+	}
+	if g.client.gameState.ZoneRadius > 0 {
+		msg += "大逃杀进行中，等待下一回合"
+	} else if me.RespawnIn > 0 {
+		msg += fmt.Sprintf("%.1f 秒后可以复活", float32(me.RespawnIn)/float32(g.tickHz))
+	} else {
+		msg += "按 R 复活"
+	}
+	ebitenutil.DebugPrintAt(screen, msg, g.screenW/2-100, g.screenH/2-20)
+}
+
+// drawVirus 绘制一个带尖刺的绿色圆
+func drawVirus(screen *ebiten.Image, sx, sy, radius float32) {
+	const spikes = 18
+	virusColor := color.RGBA{60, 220, 60, 255}
+	for i := 0; i < spikes; i++ {
+		a := 2 * math.Pi * float64(i) / spikes
+		cos, sin := float32(math.Cos(a)), float32(math.Sin(a))
+		vector.StrokeLine(screen, sx+cos*radius*0.8, sy+sin*radius*0.8, sx+cos*radius*1.15, sy+sin*radius*1.15, 2, virusColor, true)
+	}
+	vector.DrawFilledCircle(screen, sx, sy, radius, virusColor, true)
+	vector.StrokeCircle(screen, sx, sy, radius, 2, color.RGBA{30, 140, 30, 255}, true)
+}
+
+// 团队模式下按队伍着色（1 队为红，2 队为蓝……）
+var teamColors = []color.RGBA{
+	{255, 90, 90, 255},  // 红
+	{90, 140, 255, 255}, // 蓝
+	{90, 230, 120, 255}, // 绿
+	{255, 220, 90, 255}, // 黄
+}
+
+// 所有玩家都根据 ID 使用相同的颜色算法，确保在不同客户端看到相同颜色
+var playerColors = []color.RGBA{
+	{100, 150, 255, 255}, // 蓝（ID 0）
+	{255, 100, 100, 255}, // 红（ID 1）
+	{255, 200, 100, 255}, // 橙（ID 2）
+	{200, 100, 255, 255}, // 紫（ID 3）
+	{100, 255, 200, 255}, // 青（ID 4）
+	{255, 100, 200, 255}, // 粉（ID 5）
+	{200, 255, 100, 255}, // 黄绿（ID 6）
+	{255, 255, 100, 255}, // 黄（ID 7）
+}
+
+// colorFor 返回玩家的颜色：有队伍时使用队伍颜色，否则按 ID 取色
+// 调用方需持有 gs.mu
+func (gs *GameState) colorFor(id uint16) color.RGBA {
+	if p := gs.Players[id]; p != nil && p.Team != game.NoTeam {
+		return teamColors[int(p.Team-1)%len(teamColors)]
+	}
+	return playerColors[int(id)%len(playerColors)]
+}
+
 func (g *Game) Layout(outsideWidth, outsideHeight int) (int, int) {
 	return g.screenW, g.screenH
 }
@@ -447,14 +1022,18 @@ func (g *Game) Layout(outsideWidth, outsideHeight int) (int, int) {
 func main() {
 	var playerID int
 	var serverAddr string
+	var snapshotAddr string
 	var tickHz int
+	var mouseSteer bool
 
 	flag.IntVar(&playerID, "id", 1, "Player ID")
 	flag.StringVar(&serverAddr, "server", "localhost:30000", "Server address")
+	flag.StringVar(&snapshotAddr, "snapshots", "", "Snapshot channel address (default: server port + 1)")
 	flag.IntVar(&tickHz, "hz", 60, "Tick rate")
+	flag.BoolVar(&mouseSteer, "mouse", false, "Steer toward the mouse cursor when no key is pressed")
 	flag.Parse()
 
-	client, err := NewClient(uint16(playerID), serverAddr)
+	client, err := NewClient(uint16(playerID), serverAddr, snapshotAddr)
 	if err != nil {
 		fmt.Printf("Failed to create client: %v\n", err)
 		return
@@ -462,20 +1041,22 @@ func main() {
 
 	// 启动网络循环
 	go client.RecvLoop()
+	go client.SnapshotLoop()
+	go client.SnapshotSubscribeLoop()
 	go client.ReliableRetransmitLoop()
 	go client.InputLoop(tickHz)
 
 	fmt.Printf("Connecting to server %s as player %d...\n", serverAddr, playerID)
-	fmt.Println("Use arrow keys or WASD to move")
+	fmt.Println("Use arrow keys or WASD to move, Space to split, E to eject mass, R to respawn")
 	fmt.Println("💡 提示：请确保游戏窗口获得焦点（点击窗口），然后按 WASD 或方向键")
 
 	// 创建游戏并运行
-	game := NewGame(client)
+	g := NewGame(client, mouseSteer, tickHz)
 	ebiten.SetWindowSize(800, 600)
 	ebiten.SetWindowTitle("球球大作战 - Ball Battle")
 	ebiten.SetWindowResizable(true)
 
-	if err := ebiten.RunGame(game); err != nil {
+	if err := ebiten.RunGame(g); err != nil {
 		fmt.Printf("Game error: %v\n", err)
 	}
 }
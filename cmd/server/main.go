@@ -5,23 +5,92 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 
+	"ballbattle/internal/game"
 	"ballbattle/internal/server"
 )
 
 func main() {
 	var listen string
+	var snapshotListen string
 	var hz int
 	var foodCount int
 	var arenaSize float64
+	var seed int64
+	var rulesPath string
+	var mapPath string
+	rules := game.DefaultRules()
 	flag.StringVar(&listen, "listen", ":30000", "UDP listen addr")
+	flag.StringVar(&snapshotListen, "snapshot-listen", ":30001", "UDP addr of the per-client snapshot channel (empty = full snapshots in the broadcast frames)")
 	flag.IntVar(&hz, "hz", 60, "tick rate")
-	flag.IntVar(&foodCount, "foods", 120, "number of food pellets")
+	flag.IntVar(&foodCount, "foods", 120, "base number of food pellets (see -food-per-player)")
 	flag.Float64Var(&arenaSize, "size", 100, "arena half-size (square from -size..size)")
+	flag.Int64Var(&seed, "seed", 0, "RNG seed for a reproducible simulation (0 = random)")
+	flag.StringVar(&mapPath, "map", "", "JSON map file with obstacles and walls (its size, if set, overrides -size)")
+	flag.StringVar(&rulesPath, "rules", "", "JSON game rules file; rule flags given on the command line override it")
+	flag.StringVar((*string)(&rules.Mode), "mode", string(rules.Mode), "game mode: ffa, teams or royale")
+	flag.IntVar(&rules.Teams, "teams", rules.Teams, "number of teams in -mode teams")
+	flag.Var((*float32Value)(&rules.FoodPerPlayer), "food-per-player", "world pellets added to -foods for every live player")
+	flag.IntVar(&rules.FoodMin, "food-min", rules.FoodMin, "lower bound of the food target")
+	flag.IntVar(&rules.FoodMax, "food-max", rules.FoodMax, "upper bound of the food target (0 = none)")
+	flag.IntVar(&rules.FoodSpawnRate, "food-rate", rules.FoodSpawnRate, "pellets spawned per tick while below the food target (0 = instantly)")
+	flag.IntVar(&rules.BotCount, "bots", rules.BotCount, "keep this many players in the game by adding server-side bots (0 = none)")
+	flag.Var((*float32Value)(&rules.BotDifficulty), "bot-difficulty", "bot skill from 0 (clumsy) to 1 (sharp)")
+	flag.Var((*float32Value)(&rules.EatRatio), "eat-ratio", "radius ratio required to eat another player")
+	flag.Var((*float32Value)(&rules.EatOverlap), "eat-overlap", "fraction (0..1) of the smaller player that must be covered to eat it")
+	flag.Var((*float32Value)(&rules.Acceleration), "accel", "fraction of top speed gained per tick while steering")
+	flag.Var((*float32Value)(&rules.Friction), "friction", "fraction (0..1) of velocity kept each tick")
+	flag.Var((*float32Value)(&rules.DecayThreshold), "decay-threshold", "cell radius above which mass decays")
+	flag.Var((*float32Value)(&rules.DecayRate), "decay-rate", "fraction of the radius above -decay-threshold lost per tick (0 = off)")
+	flag.Var((*uint32Value)(&rules.RespawnCooldown), "respawn-cooldown", "ticks a dead player must wait before respawning")
+	flag.Var((*uint32Value)(&rules.RespawnAutoTicks), "respawn-auto", "ticks after which dead players respawn automatically (0 = only on request)")
+	flag.Var((*float32Value)(&rules.SpawnClearance), "spawn-clearance", "minimum gap between a spawning player and larger players")
+	flag.Var((*float32Value)(&rules.SpawnThreatRadius), "spawn-threat-radius", "radius from which players are avoided when spawning (0 = any that could eat the newcomer)")
+	flag.Var((*uint32Value)(&rules.RoundTicks), "round-ticks", "round length in ticks (0 = one endless session)")
+	flag.Var((*uint32Value)(&rules.WarmupTicks), "warmup-ticks", "frozen countdown before each round")
+	flag.Var((*uint32Value)(&rules.RoundEndTicks), "round-end-ticks", "ticks the final board and winner are shown")
+	flag.Var((*uint32Value)(&rules.IntermissionTicks), "intermission-ticks", "free-roam break between rounds")
+	flag.Var((*uint32Value)(&rules.ZoneWaitTicks), "zone-wait", "royale: ticks the safe zone holds before each shrink")
+	flag.Var((*uint32Value)(&rules.ZoneShrinkTicks), "zone-shrink", "royale: ticks each shrink takes")
+	flag.Var((*float32Value)(&rules.ZoneDamage), "zone-damage", "royale: fraction of mass lost per tick outside the safe zone")
+	flag.Var((*float32Value)(&rules.InterestRadius), "interest-radius", "radius around each player's camera whose entities it is sent (0 = whole world)")
+	flag.Var((*float32Value)(&rules.InterestScale), "interest-scale", "extra interest radius per unit of player size")
 	flag.Parse()
 
-	srv, err := server.New(listen, hz, foodCount, float32(arenaSize))
+	if rulesPath != "" {
+		loaded, err := game.LoadRules(rulesPath)
+		if err != nil {
+			log.Fatalf("load rules: %v", err)
+		}
+		rules = loaded
+		// 再解析一次，让命令行上显式给出的规则参数覆盖文件中的值
+		flag.CommandLine.Parse(os.Args[1:])
+	}
+
+	if !rules.Mode.Valid() {
+		log.Fatalf("unknown mode %q", rules.Mode)
+	}
+	if rules.Mode == game.ModeRoyale && rules.RoundTicks == 0 {
+		log.Fatalf("-mode royale needs rounds, set -round-ticks (e.g. 10800 for 3 minutes at 60 Hz)")
+	}
+	if rules.Mode == game.ModeTeams && (rules.Teams < 2 || rules.Teams > 255) {
+		log.Fatalf("-teams must be between 2 and 255, got %d", rules.Teams)
+	}
+
+	var arena *game.ArenaMap
+	if mapPath != "" {
+		var err error
+		if arena, err = game.LoadMap(mapPath); err != nil {
+			log.Fatalf("load map: %v", err)
+		}
+		if arena.Size > 0 {
+			arenaSize = float64(arena.Size)
+		}
+	}
+
+	srv, err := server.New(listen, snapshotListen, hz, foodCount, float32(arenaSize), rules, seed, arena)
 	if err != nil {
 		log.Fatalf("create server: %v", err)
 	}
@@ -30,8 +99,9 @@ func main() {
 	go srv.ReliableRetransmitLoop() // 广播游戏帧
 	go srv.BroadcastLoop()          // 可靠消息重传
 	go srv.CheckPlayerTimeout()     // 玩家超时检测
+	go srv.SnapshotLoop()           // 快照订阅和确认
 
-	log.Printf("ballbattle server started on %s (hz=%d, foods=%d, size=%.1f)", listen, hz, foodCount, arenaSize)
+	log.Printf("ballbattle server started on %s (mode=%s, hz=%d, foods=%d, size=%.1f, seed=%d)", listen, rules.Mode, hz, foodCount, arenaSize, seed)
 
 	// graceful shutdown
 	c := make(chan os.Signal, 1)
@@ -39,3 +109,35 @@ func main() {
 	<-c
 	log.Println("server exiting")
 }
+
+// float32Value 让 float32 字段可以直接作为命令行参数
+type float32Value float32
+
+func (v *float32Value) String() string {
+	return strconv.FormatFloat(float64(*v), 'g', -1, 32)
+}
+
+func (v *float32Value) Set(s string) error {
+	f, err := strconv.ParseFloat(s, 32)
+	if err != nil {
+		return err
+	}
+	*v = float32Value(f)
+	return nil
+}
+
+// uint32Value 让 uint32 字段可以直接作为命令行参数
+type uint32Value uint32
+
+func (v *uint32Value) String() string {
+	return strconv.FormatUint(uint64(*v), 10)
+}
+
+func (v *uint32Value) Set(s string) error {
+	n, err := strconv.ParseUint(s, 10, 32)
+	if err != nil {
+		return err
+	}
+	*v = uint32Value(n)
+	return nil
+}
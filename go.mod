@@ -6,13 +6,15 @@ toolchain go1.24.11
 
 replace gameframework => ../gameframework
 
-require gameframework v0.0.0-20251212091513-ac250506ec8a
+require (
+	gameframework v0.0.0-20251212091513-ac250506ec8a
+	github.com/hajimehoshi/ebiten/v2 v2.9.5
+)
 
 require (
 	github.com/ebitengine/gomobile v0.0.0-20250923094054-ea854a63cce1 // indirect
 	github.com/ebitengine/hideconsole v1.0.0 // indirect
 	github.com/ebitengine/purego v0.9.0 // indirect
-	github.com/hajimehoshi/ebiten/v2 v2.9.5 // indirect
 	github.com/jezek/xgb v1.1.1 // indirect
 	golang.org/x/sync v0.17.0 // indirect
 	golang.org/x/sys v0.39.0 // indirect
@@ -0,0 +1,123 @@
+// Package channel 定义快照通道（internal/server 的第二个 UDP 端口）上服务器和客户端共用的消息格式。
+// 每个数据包都以框架的 UDP 头部（proto.WriteUDPHeader）开头，下面的格式是头部之后的部分。
+//
+// 客户端先经框架的主连接发送一条可靠消息登记令牌：
+//
+//	[MsgToken: uint8] [Token: uint64]
+//
+// 服务器记下框架为该玩家传入的来源地址和令牌。之后客户端在快照通道上定期发送订阅包：
+//
+//	[PlayerID: uint16] [HasAck: uint8] [Token: uint64] [Stream: uint32] [EventAck: uint32]
+//
+// 令牌不符或来源 IP 与主连接不同的订阅包被丢弃，因此不能把别人的快照引到自己或任意地址。
+//
+// 除了快照分片，服务器还在快照通道上给每个玩家发送事件（地图几何、回合公告等只发一次的消息）：
+//
+//	[EventMagic: 2 bytes] [Stream: uint32] [Seq: uint32] [Payload: ...]
+//
+// 每个玩家的事件属于一个事件流，Seq 从 1 开始连续编号。客户端只接受当前流的下一个事件，
+// 或另一个流的第 1 个事件（服务器重新开始了事件流），并在订阅包中用 Stream/EventAck
+// 确认按顺序收到的最后一个事件；服务器定期重发还没有确认的事件。
+package channel
+
+import (
+	"encoding/binary"
+)
+
+// MsgToken 是客户端经框架可靠消息登记快照通道令牌的消息类型（游戏消息从 0x80 开始）
+const MsgToken byte = 0x82
+
+// TokenSize 是令牌的字节数
+const TokenSize = 8
+
+// AppendToken 追加登记令牌的可靠消息内容
+func AppendToken(dst []byte, token uint64) []byte {
+	dst = append(dst, MsgToken)
+	return binary.LittleEndian.AppendUint64(dst, token)
+}
+
+// ParseToken 取出 MsgToken 消息中的令牌。不论框架传入的内容是否还带着类型字节，令牌都是最后 8 个字节
+func ParseToken(payload []byte) (uint64, bool) {
+	if len(payload) < TokenSize {
+		return 0, false
+	}
+	return binary.LittleEndian.Uint64(payload[len(payload)-TokenSize:]), true
+}
+
+// Subscribe 是客户端在快照通道上发送的订阅包，同时确认收到的快照（确认在 UDP 头部的 ack/ackBits 中）
+// 和事件
+type Subscribe struct {
+	PlayerID uint16
+	HasAck   bool // UDP 头部的 ack/ackBits 有效，即客户端已经解码过快照
+	Token    uint64
+	Stream   uint32 // 客户端当前的事件流，还没有收到事件时为 0
+	EventAck uint32 // 该流中按顺序收到的最后一个事件
+}
+
+// subscribeSize 是订阅包（不含 UDP 头部）的长度
+const subscribeSize = 2 + 1 + TokenSize + 4 + 4
+
+// AppendSubscribe 追加订阅包
+func AppendSubscribe(dst []byte, s Subscribe) []byte {
+	dst = binary.LittleEndian.AppendUint16(dst, s.PlayerID)
+	var hasAck uint8
+	if s.HasAck {
+		hasAck = 1
+	}
+	dst = append(dst, hasAck)
+	dst = binary.LittleEndian.AppendUint64(dst, s.Token)
+	dst = binary.LittleEndian.AppendUint32(dst, s.Stream)
+	return binary.LittleEndian.AppendUint32(dst, s.EventAck)
+}
+
+// ParseSubscribe 解析订阅包，长度不对时返回 false
+func ParseSubscribe(b []byte) (Subscribe, bool) {
+	if len(b) != subscribeSize {
+		return Subscribe{}, false
+	}
+	return Subscribe{
+		PlayerID: binary.LittleEndian.Uint16(b),
+		HasAck:   b[2] != 0,
+		Token:    binary.LittleEndian.Uint64(b[3:]),
+		Stream:   binary.LittleEndian.Uint32(b[11:]),
+		EventAck: binary.LittleEndian.Uint32(b[15:]),
+	}, true
+}
+
+// EventMagic 标记一个事件包，用于和快照分片（fragment.Magic）区分
+var EventMagic = [2]byte{0xBB, 0xF8}
+
+const (
+	// EventHeaderSize 是事件包头部的长度
+	EventHeaderSize = 2 + 4 + 4
+	// MaxEventSize 是事件内容的最大长度。事件不分片，加上 8 字节 UDP 头部和事件包头部后
+	// 必须放进一个 IPv4 UDP 数据报（65507 字节）
+	MaxEventSize = 65507 - 8 - EventHeaderSize
+)
+
+// Event 是服务器在快照通道上发送的一个事件，Payload 以游戏的消息类型开头
+type Event struct {
+	Stream  uint32
+	Seq     uint32
+	Payload []byte
+}
+
+// AppendEvent 追加事件包
+func AppendEvent(dst []byte, e Event) []byte {
+	dst = append(dst, EventMagic[:]...)
+	dst = binary.LittleEndian.AppendUint32(dst, e.Stream)
+	dst = binary.LittleEndian.AppendUint32(dst, e.Seq)
+	return append(dst, e.Payload...)
+}
+
+// ParseEvent 解析事件包，不是事件包时返回 false。Payload 引用原始数据包
+func ParseEvent(b []byte) (Event, bool) {
+	if len(b) <= EventHeaderSize || b[0] != EventMagic[0] || b[1] != EventMagic[1] {
+		return Event{}, false
+	}
+	return Event{
+		Stream:  binary.LittleEndian.Uint32(b[2:]),
+		Seq:     binary.LittleEndian.Uint32(b[6:]),
+		Payload: b[EventHeaderSize:],
+	}, true
+}
@@ -0,0 +1,136 @@
+// Package fragment 把快照切分成不超过 MTU 的分片并在接收端重组，
+// 使快照可以超过单个 UDP 数据报的大小。
+//
+// 快照总是以分片形式发送（小快照只有一个分片）：
+//
+//	[Magic: 2 bytes] [Tick: uint32] [Index: uint16] [Count: uint16] [Data: ...]
+//
+// 所有分片的 Data 按 Index 顺序拼接后得到快照。
+// 服务器的快照通道（internal/server）用 Split 发送，客户端用 Parse 和 Reassembler 接收。
+package fragment
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// Magic 标记一个分片，用于和可靠消息区分
+var Magic = [2]byte{0xBB, 0xF7}
+
+const (
+	// HeaderSize 是分片头部的长度
+	HeaderSize = 2 + 4 + 2 + 2
+	// MaxPayload 是单个分片（含分片头部）的最大长度。加上 8 字节 UDP 头部和 IP/UDP 头部后
+	// 仍小于 IPv6 的最小 MTU 1280，不会在路径上被 IP 分片
+	MaxPayload = 1200
+	// MaxFragments 限制一帧的分片数，防止异常数据包占用大量内存
+	MaxFragments = 256
+)
+
+// Fragment 是解析后的一个分片，Data 引用原始数据包
+type Fragment struct {
+	Tick  uint32
+	Index uint16
+	Count uint16
+	Data  []byte
+}
+
+// Split 把帧体切分成分片，每个分片（含头部）不超过 maxPayload 字节
+func Split(tick uint32, frame []byte, maxPayload int) ([][]byte, error) {
+	chunk := maxPayload - HeaderSize
+	if chunk <= 0 {
+		return nil, fmt.Errorf("fragment size %d too small for the %d-byte header", maxPayload, HeaderSize)
+	}
+	count := max((len(frame)+chunk-1)/chunk, 1)
+	if count > MaxFragments {
+		return nil, fmt.Errorf("frame too large: %d bytes need %d fragments (limit %d)", len(frame), count, MaxFragments)
+	}
+	out := make([][]byte, 0, count)
+	for i := 0; i < count; i++ {
+		data := frame[i*chunk : min((i+1)*chunk, len(frame))]
+		buf := &bytes.Buffer{}
+		buf.Grow(HeaderSize + len(data))
+		buf.Write(Magic[:])
+		binary.Write(buf, binary.LittleEndian, tick)
+		binary.Write(buf, binary.LittleEndian, uint16(i))
+		binary.Write(buf, binary.LittleEndian, uint16(count))
+		buf.Write(data)
+		out = append(out, buf.Bytes())
+	}
+	return out, nil
+}
+
+// Parse 解析一个分片，不是分片或头部非法时返回 false
+func Parse(b []byte) (Fragment, bool) {
+	if len(b) < HeaderSize || b[0] != Magic[0] || b[1] != Magic[1] {
+		return Fragment{}, false
+	}
+	f := Fragment{
+		Tick:  binary.LittleEndian.Uint32(b[2:]),
+		Index: binary.LittleEndian.Uint16(b[6:]),
+		Count: binary.LittleEndian.Uint16(b[8:]),
+		Data:  b[HeaderSize:],
+	}
+	if f.Count == 0 || f.Count > MaxFragments || f.Index >= f.Count {
+		return Fragment{}, false
+	}
+	return f, true
+}
+
+// partial 是一帧正在重组的分片
+type partial struct {
+	parts    [][]byte
+	received int
+	first    time.Time // 收到第一个分片的时间
+}
+
+// Reassembler 按 tick 重组分片。超过 timeout 仍不完整的帧被丢弃，
+// 因为快照很快就会过时，等待丢失的分片没有意义。不是并发安全的
+type Reassembler struct {
+	timeout time.Duration
+	pending map[uint32]*partial
+}
+
+func NewReassembler(timeout time.Duration) *Reassembler {
+	return &Reassembler{timeout: timeout, pending: make(map[uint32]*partial)}
+}
+
+// Add 加入一个分片（Data 会被复制），帧完整时返回拼接后的帧体
+func (r *Reassembler) Add(f Fragment, now time.Time) (frame []byte, done bool) {
+	r.expire(now)
+	if f.Count == 1 {
+		return bytes.Clone(f.Data), true
+	}
+	p := r.pending[f.Tick]
+	if p == nil {
+		p = &partial{parts: make([][]byte, f.Count), first: now}
+		r.pending[f.Tick] = p
+	}
+	if int(f.Count) != len(p.parts) || p.parts[f.Index] != nil {
+		// 分片数不一致或重复的分片
+		return nil, false
+	}
+	p.parts[f.Index] = bytes.Clone(f.Data)
+	p.received++
+	if p.received < len(p.parts) {
+		return nil, false
+	}
+	delete(r.pending, f.Tick)
+	return bytes.Join(p.parts, nil), true
+}
+
+// Pending 返回正在重组的帧数
+func (r *Reassembler) Pending() int {
+	return len(r.pending)
+}
+
+// expire 丢弃超时的不完整帧
+func (r *Reassembler) expire(now time.Time) {
+	for tick, p := range r.pending {
+		if now.Sub(p.first) > r.timeout {
+			delete(r.pending, tick)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package game
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+)
+
+// ArenaMap is the static geometry of an arena: circular and rectangular
+// obstacles and walls. Cells, flying pellets and shot viruses cannot enter
+// it and slide along its edges. The zero value is an empty arena.
+type ArenaMap struct {
+	// Size, when positive, overrides the arena half-size.
+	Size    float32      `json:"size"`
+	Circles []CircleObst `json:"circles"`
+	Rects   []RectObst   `json:"rects"`
+	Walls   []Wall       `json:"walls"`
+}
+
+// CircleObst is a round obstacle.
+type CircleObst struct {
+	X      float32 `json:"x"`
+	Y      float32 `json:"y"`
+	Radius float32 `json:"radius"`
+}
+
+// RectObst is an axis-aligned box given by its centre and half extents.
+type RectObst struct {
+	X     float32 `json:"x"`
+	Y     float32 `json:"y"`
+	HalfW float32 `json:"halfW"`
+	HalfH float32 `json:"halfH"`
+}
+
+// Wall is a straight segment with a thickness, i.e. a capsule.
+type Wall struct {
+	X1        float32 `json:"x1"`
+	Y1        float32 `json:"y1"`
+	X2        float32 `json:"x2"`
+	Y2        float32 `json:"y2"`
+	Thickness float32 `json:"thickness"`
+}
+
+// LoadMap reads a JSON map file.
+func LoadMap(path string) (*ArenaMap, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	m := &ArenaMap{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// empty reports whether m has no geometry (m may be nil).
+func (m *ArenaMap) empty() bool {
+	return m == nil || len(m.Circles)+len(m.Rects)+len(m.Walls) == 0
+}
+
+// resolve pushes a circle of radius r at (x, y) out of every obstacle it
+// overlaps and removes the velocity component pointing into the obstacle,
+// so a moving circle slides along the surface instead of stopping dead.
+func (m *ArenaMap) resolve(x, y, vx, vy, r float32) (float32, float32, float32, float32) {
+	push := func(qx, qy, reach float32) {
+		dx, dy := x-qx, y-qy
+		d := float32(math.Hypot(float64(dx), float64(dy)))
+		if d >= reach {
+			return
+		}
+		nx, ny := float32(1), float32(0)
+		if d > 0 {
+			nx, ny = dx/d, dy/d
+		}
+		x, y = qx+nx*reach, qy+ny*reach
+		if dot := vx*nx + vy*ny; dot < 0 {
+			vx, vy = vx-nx*dot, vy-ny*dot
+		}
+	}
+	for _, o := range m.Circles {
+		push(o.X, o.Y, o.Radius+r)
+	}
+	for _, w := range m.Walls {
+		qx, qy := closestOnSegment(x, y, w.X1, w.Y1, w.X2, w.Y2)
+		push(qx, qy, w.Thickness/2+r)
+	}
+	for _, b := range m.Rects {
+		qx := clamp(x, b.X-b.HalfW, b.X+b.HalfW)
+		qy := clamp(y, b.Y-b.HalfH, b.Y+b.HalfH)
+		if qx != x || qy != y {
+			push(qx, qy, r)
+			continue
+		}
+		// centre inside the box: leave through the nearest side
+		left, right := x-(b.X-b.HalfW), b.X+b.HalfW-x
+		down, up := y-(b.Y-b.HalfH), b.Y+b.HalfH-y
+		switch min(left, right, down, up) {
+		case left:
+			x, vx = b.X-b.HalfW-r, min(vx, 0)
+		case right:
+			x, vx = b.X+b.HalfW+r, max(vx, 0)
+		case down:
+			y, vy = b.Y-b.HalfH-r, min(vy, 0)
+		default:
+			y, vy = b.Y+b.HalfH+r, max(vy, 0)
+		}
+	}
+	return x, y, vx, vy
+}
+
+// blocked reports whether a circle of radius r at (x, y) overlaps any
+// obstacle.
+func (m *ArenaMap) blocked(x, y, r float32) bool {
+	for _, o := range m.Circles {
+		if collide(x, y, r, o.X, o.Y, o.Radius) {
+			return true
+		}
+	}
+	for _, w := range m.Walls {
+		qx, qy := closestOnSegment(x, y, w.X1, w.Y1, w.X2, w.Y2)
+		if collide(x, y, r, qx, qy, w.Thickness/2) {
+			return true
+		}
+	}
+	for _, b := range m.Rects {
+		qx := clamp(x, b.X-b.HalfW, b.X+b.HalfW)
+		qy := clamp(y, b.Y-b.HalfH, b.Y+b.HalfH)
+		if collide(x, y, r, qx, qy, 0) {
+			return true
+		}
+	}
+	return false
+}
+
+// closestOnSegment returns the point of segment (x1,y1)-(x2,y2) closest to
+// (x, y).
+func closestOnSegment(x, y, x1, y1, x2, y2 float32) (float32, float32) {
+	dx, dy := x2-x1, y2-y1
+	l2 := dx*dx + dy*dy
+	if l2 == 0 {
+		return x1, y1
+	}
+	t := clamp(((x-x1)*dx+(y-y1)*dy)/l2, 0, 1)
+	return x1 + t*dx, y1 + t*dy
+}
+
+// openPointAttempts is how many random positions openPoint tries before
+// settling for a blocked one.
+const openPointAttempts = 20
+
+// openPoint returns a random position where a circle of radius r does not
+// overlap the map geometry.
+func (s *State) openPoint(r float32) (x, y float32) {
+	for i := 0; i < openPointAttempts; i++ {
+		x, y = s.randInRange(), s.randInRange()
+		if s.arena.empty() || !s.arena.blocked(x, y, r) {
+			break
+		}
+	}
+	return x, y
+}
+
+// bounce keeps a moving circle inside the arena and out of the map geometry.
+func (s *State) bounce(x, y, vx, vy, r float32) (float32, float32, float32, float32) {
+	if !s.arena.empty() {
+		x, y, vx, vy = s.arena.resolve(x, y, vx, vy, r)
+	}
+	return clamp(x, -s.arenaHalf, s.arenaHalf), clamp(y, -s.arenaHalf, s.arenaHalf), vx, vy
+}
+
+// MsgMapGeometry is the message carrying the arena geometry. It is sent once
+// to every player as an event on the snapshot channel instead of with each
+// snapshot.
+const MsgMapGeometry byte = 0x81
+
+// EncodeMap builds a MsgMapGeometry payload:
+//
+//	msgType(uint8), arenaHalf(float32)
+//	uint16 circleCount, [x, y, radius(float32)]*C
+//	uint16 rectCount, [x, y, halfW, halfH(float32)]*R
+//	uint16 wallCount, [x1, y1, x2, y2, thickness(float32)]*W
+func EncodeMap(arenaHalf float32, m *ArenaMap) []byte {
+	if m == nil {
+		m = &ArenaMap{}
+	}
+	buf := &bytes.Buffer{}
+	buf.WriteByte(MsgMapGeometry)
+	binary.Write(buf, binary.LittleEndian, arenaHalf)
+	binary.Write(buf, binary.LittleEndian, uint16(len(m.Circles)))
+	binary.Write(buf, binary.LittleEndian, m.Circles)
+	binary.Write(buf, binary.LittleEndian, uint16(len(m.Rects)))
+	binary.Write(buf, binary.LittleEndian, m.Rects)
+	binary.Write(buf, binary.LittleEndian, uint16(len(m.Walls)))
+	binary.Write(buf, binary.LittleEndian, m.Walls)
+	return buf.Bytes()
+}
+
+// DecodeMap parses a payload built by EncodeMap.
+func DecodeMap(payload []byte) (arenaHalf float32, m *ArenaMap, err error) {
+	if len(payload) == 0 || payload[0] != MsgMapGeometry {
+		return 0, nil, fmt.Errorf("not a map message")
+	}
+	r := bytes.NewReader(payload[1:])
+	m = &ArenaMap{}
+	var n uint16
+	binary.Read(r, binary.LittleEndian, &arenaHalf)
+	binary.Read(r, binary.LittleEndian, &n)
+	m.Circles = make([]CircleObst, n)
+	binary.Read(r, binary.LittleEndian, m.Circles)
+	binary.Read(r, binary.LittleEndian, &n)
+	m.Rects = make([]RectObst, n)
+	binary.Read(r, binary.LittleEndian, m.Rects)
+	binary.Read(r, binary.LittleEndian, &n)
+	m.Walls = make([]Wall, n)
+	if err := binary.Read(r, binary.LittleEndian, m.Walls); err != nil {
+		return 0, nil, err
+	}
+	return arenaHalf, m, nil
+}
@@ -0,0 +1,151 @@
+package game
+
+import (
+	"math"
+	"math/rand"
+)
+
+// BotIDBase is the first player ID handed to server-side bots. Bots take
+// the lowest free ID from here upwards that no human uses. Clients may
+// still pick any ID; a human joining with a bot's ID takes it over.
+const BotIDBase uint16 = 60000
+
+// IsBot reports whether pid lies in the range bots take their IDs from.
+// A human who joined with such an ID is not a bot (see OnJoin).
+func IsBot(pid uint16) bool {
+	return pid >= BotIDBase && pid < NoOwner
+}
+
+// bot is the per-bot memory kept between ticks.
+type bot struct {
+	id    uint16
+	rng   *rand.Rand
+	input uint32 // last steering decision, repeated until the bot reacts again
+	// wander direction used when there is nothing worth chasing in sight
+	wanderX, wanderY float32
+}
+
+func (s *State) newBot(id uint16) *bot {
+	return &bot{id: id, rng: rand.New(rand.NewSource(s.rng.Int63())), wanderX: 1}
+}
+
+// botInputs decides the input of every bot for this tick and stores it in
+// inputs. Bots see the world as of the end of the previous tick.
+func (s *State) botInputs(bots []*bot, inputs map[uint16]uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, b := range bots {
+		inputs[b.id] = s.think(b)
+	}
+}
+
+// think returns b's input. Better bots (BotDifficulty towards 1) react
+// more often, see further, aim more precisely and split to catch prey.
+func (s *State) think(b *bot) uint32 {
+	p := s.Players[b.id]
+	if p == nil {
+		return InputNone
+	}
+	if p.Status == StatusDead {
+		return EncodeInput(0, 0, InputActionRespawn)
+	}
+	skill := clamp(s.rules.BotDifficulty, 0, 1)
+	reaction := uint32(15 - 13*skill)
+	if s.tick%reaction != uint32(b.id)%reaction {
+		return b.input
+	}
+
+	// steer by the biggest cell: it is the one most at risk and most able to hunt
+	me := p.Cells[0]
+	for _, c := range p.Cells[1:] {
+		if c.Radius > me.Radius {
+			me = c
+		}
+	}
+	sight := me.Radius + 25 + 25*skill
+
+	// flee from everything that could eat or pop us, weighted by closeness
+	var fleeX, fleeY float32
+	var prey *Cell
+	preyDist := sight
+	s.cellHits = s.grid.queryCells(s.cellHits[:0], me.X, me.Y, sight)
+	for _, hit := range s.cellHits {
+		if hit.p == p || teammates(hit.p, p) {
+			continue
+		}
+		c := hit.c
+		dx, dy := me.X-c.X, me.Y-c.Y
+		d := float32(math.Hypot(float64(dx), float64(dy)))
+		if d == 0 || d > sight+c.Radius {
+			continue
+		}
+		switch {
+		case c.Radius >= me.Radius*s.rules.EatRatio && !p.has(PowerShield):
+			w := c.Radius / (d * d)
+			fleeX, fleeY = fleeX+dx*w, fleeY+dy*w
+		case me.Radius >= c.Radius*s.rules.EatRatio && d < preyDist && !hit.p.has(PowerShield):
+			prey, preyDist = c, d
+		}
+	}
+	if me.Radius >= s.rules.VirusRadius*s.rules.EatRatio {
+		for _, v := range s.Viruses {
+			dx, dy := me.X-v.X, me.Y-v.Y
+			if d := float32(math.Hypot(float64(dx), float64(dy))); d > 0 && d < me.Radius+v.Radius+5 {
+				w := v.Radius / (d * d)
+				fleeX, fleeY = fleeX+dx*w, fleeY+dy*w
+			}
+		}
+	}
+
+	var dx, dy float32
+	var actions uint32
+	switch {
+	case fleeX != 0 || fleeY != 0:
+		dx, dy = fleeX, fleeY
+	case prey != nil:
+		dx, dy = prey.X-me.X, prey.Y-me.Y
+		// split onto prey that the halves can still eat and the launch can reach
+		half := massToRadius(radiusToMass(me.Radius) / 2)
+		if skill >= 0.5 && half >= prey.Radius*s.rules.EatRatio && preyDist < me.Radius+4*s.rules.SplitSpeed &&
+			len(p.Cells) < s.rules.MaxCells && me.Radius >= s.rules.SplitMinRadius {
+			actions |= InputActionSplit
+		}
+	default:
+		if f := s.nearestFood(p, me, sight); f != nil {
+			dx, dy = f.X-me.X, f.Y-me.Y
+		} else {
+			if b.rng.Intn(60) == 0 {
+				a := b.rng.Float64() * 2 * math.Pi
+				b.wanderX, b.wanderY = float32(math.Cos(a)), float32(math.Sin(a))
+			}
+			dx, dy = b.wanderX, b.wanderY
+		}
+	}
+
+	// clumsier bots aim worse
+	if noise := (1 - skill) * 0.6; noise > 0 {
+		a := math.Atan2(float64(dy), float64(dx)) + (b.rng.Float64()*2-1)*float64(noise)
+		dx, dy = float32(math.Cos(a)), float32(math.Sin(a))
+	} else if d := float32(math.Hypot(float64(dx), float64(dy))); d > 0 {
+		dx, dy = dx/d, dy/d
+	}
+	b.input = EncodeInput(dx, dy, 0)
+	return EncodeInput(dx, dy, actions)
+}
+
+// nearestFood returns the closest pellet within sight that p may eat.
+func (s *State) nearestFood(p *Player, me *Cell, sight float32) *Food {
+	var best *Food
+	bestD := sight * sight
+	s.foodHits = s.grid.queryFoods(s.foodHits[:0], me.X, me.Y, sight)
+	for _, f := range s.foodHits {
+		if f.Owner == p.ID && (f.VX != 0 || f.VY != 0) {
+			continue
+		}
+		dx, dy := f.X-me.X, f.Y-me.Y
+		if d := dx*dx + dy*dy; d < bestD {
+			best, bestD = f, d
+		}
+	}
+	return best
+}
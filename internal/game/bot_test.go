@@ -0,0 +1,40 @@
+package game
+
+import "testing"
+
+// A human may join with an ID a bot already holds; the bot must make way
+// instead of steering the human or being balanced away with it.
+func TestHumanTakesOverBotID(t *testing.T) {
+	rules := DefaultRules()
+	rules.BotCount = 3
+	l := NewBallBattleLogic(NewState(100, 50, rules, 1, nil))
+	if len(l.bots) != 3 || l.bots[0].id != BotIDBase {
+		t.Fatalf("want 3 bots starting at %d, got %d", BotIDBase, len(l.bots))
+	}
+
+	l.OnJoin(BotIDBase)
+	for _, b := range l.bots {
+		if b.id == BotIDBase {
+			t.Fatalf("bot %d still runs the human's player", b.id)
+		}
+	}
+	if len(l.bots) != 2 {
+		t.Fatalf("want 2 bots next to 1 human, got %d", len(l.bots))
+	}
+
+	// steer right: a bot sharing the ID would overwrite this input
+	l.Tick(1, map[uint16]uint32{BotIDBase: EncodeInput(1, 0, 0)})
+	if p := l.state.Players[BotIDBase]; p == nil || p.InputX <= 0 || p.InputY != 0 {
+		t.Fatalf("human input was not applied: %+v", p)
+	}
+
+	// more humans push the bots out; the human holding a bot ID stays
+	l.OnJoin(1)
+	l.OnJoin(2)
+	if len(l.bots) != 0 {
+		t.Fatalf("want no bots next to 3 humans, got %d", len(l.bots))
+	}
+	if l.state.Players[BotIDBase] == nil {
+		t.Fatalf("human %d was removed as a bot", BotIDBase)
+	}
+}
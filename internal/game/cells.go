@@ -0,0 +1,82 @@
+package game
+
+import "math"
+
+// split halves every cell that is big enough and launches the new half along
+// the player's last movement direction. Both halves must wait MergeTicks
+// before they can recombine.
+func (s *State) split(p *Player) {
+	n := len(p.Cells)
+	for i := 0; i < n && len(p.Cells) < s.rules.MaxCells; i++ {
+		c := p.Cells[i]
+		if c.Radius < s.rules.SplitMinRadius {
+			continue
+		}
+		c.Radius = massToRadius(radiusToMass(c.Radius) / 2)
+		nc := s.newCell(c.X+p.DirX*c.Radius, c.Y+p.DirY*c.Radius, c.Radius)
+		nc.VX = c.VX + p.DirX*s.rules.SplitSpeed
+		nc.VY = c.VY + p.DirY*s.rules.SplitSpeed
+		c.MergeAt = s.tick + s.rules.MergeTicks
+		nc.MergeAt = c.MergeAt
+		p.Cells = append(p.Cells, nc)
+	}
+}
+
+// mergeCells recombines sibling cells whose merge timers have expired and
+// overlap, and pushes apart siblings that are still on cooldown so they do
+// not stack on top of each other.
+func (s *State) mergeCells() {
+	for _, p := range s.order {
+		for i := 0; i < len(p.Cells); i++ {
+			for j := i + 1; j < len(p.Cells); j++ {
+				a, b := p.Cells[i], p.Cells[j]
+				dx := b.X - a.X
+				dy := b.Y - a.Y
+				dist := float32(math.Sqrt(float64(dx*dx + dy*dy)))
+				if s.tick >= a.MergeAt && s.tick >= b.MergeAt {
+					if dist < a.Radius || dist < b.Radius {
+						a.Radius = massToRadius(radiusToMass(a.Radius) + radiusToMass(b.Radius))
+						p.Cells = append(p.Cells[:j], p.Cells[j+1:]...)
+						j--
+					}
+					continue
+				}
+				overlap := a.Radius + b.Radius - dist
+				if overlap <= 0 || dist == 0 {
+					continue
+				}
+				// leave cells that are still flying from a split alone
+				if launched(a, s.speedBoost(p)) || launched(b, s.speedBoost(p)) {
+					continue
+				}
+				push := overlap / 2 / dist
+				a.X = clamp(a.X-dx*push, -s.arenaHalf, s.arenaHalf)
+				a.Y = clamp(a.Y-dy*push, -s.arenaHalf, s.arenaHalf)
+				b.X = clamp(b.X+dx*push, -s.arenaHalf, s.arenaHalf)
+				b.Y = clamp(b.Y+dy*push, -s.arenaHalf, s.arenaHalf)
+			}
+		}
+	}
+}
+
+// decayCells shrinks every cell above DecayThreshold by DecayRate of the
+// excess, so big players slowly lose their lead unless they keep eating.
+func (s *State) decayCells() {
+	if s.rules.DecayRate <= 0 {
+		return
+	}
+	for _, p := range s.order {
+		for _, c := range p.Cells {
+			if excess := c.Radius - s.rules.DecayThreshold; excess > 0 {
+				c.Radius -= excess * s.rules.DecayRate
+			}
+		}
+	}
+}
+
+// launched reports whether c is moving faster than it could by steering,
+// i.e. it is still carried by a split impulse.
+func launched(c *Cell, boost float32) bool {
+	top := topSpeed(c.Radius, boost)
+	return c.VX*c.VX+c.VY*c.VY > top*top*1.01
+}
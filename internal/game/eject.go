@@ -0,0 +1,68 @@
+package game
+
+// ejectedFoodRadius is the size of a pellet ejected by a player.
+const ejectedFoodRadius = 0.5
+
+// eject makes every cell of p that is big enough shed EjectRadius and shoot
+// it out as a pellet along the player's last movement direction.
+func (s *State) eject(p *Player) {
+	for _, c := range p.Cells {
+		if c.Radius < s.rules.EjectMinRadius {
+			continue
+		}
+		c.Radius -= s.rules.EjectRadius
+		f := &Food{
+			ID:     s.newFoodID(),
+			X:      clamp(c.X+p.DirX*(c.Radius+ejectedFoodRadius), -s.arenaHalf, s.arenaHalf),
+			Y:      clamp(c.Y+p.DirY*(c.Radius+ejectedFoodRadius), -s.arenaHalf, s.arenaHalf),
+			Value:  s.rules.EjectRadius,
+			Radius: ejectedFoodRadius,
+			VX:     p.DirX * s.rules.EjectSpeed,
+			VY:     p.DirY * s.rules.EjectSpeed,
+			Owner:  p.ID,
+		}
+		s.addFood(f)
+		s.flying = append(s.flying, f)
+	}
+}
+
+// moveEjectedFood integrates every moving pellet, slows it down and lets
+// viruses or cells it flies into eat it.
+func (s *State) moveEjectedFood() {
+	kept := s.flying[:0]
+	for _, f := range s.flying {
+		if s.Foods[f.ID] != f {
+			continue // already eaten this tick
+		}
+		oldX, oldY := f.X, f.Y
+		f.X, f.Y, f.VX, f.VY = s.bounce(f.X+f.VX, f.Y+f.VY, f.VX, f.VY, f.Radius)
+		s.grid.moveFood(f, oldX, oldY)
+		if s.feedVirus(f) {
+			continue
+		}
+		f.VX *= s.rules.EjectDecay
+		f.VY *= s.rules.EjectDecay
+		if f.VX*f.VX+f.VY*f.VY < 0.0001 {
+			f.VX, f.VY = 0, 0
+		}
+		if s.feedFromFlight(f) || (f.VX == 0 && f.VY == 0) {
+			continue
+		}
+		kept = append(kept, f)
+	}
+	clear(s.flying[len(kept):])
+	s.flying = kept
+}
+
+// feedFromFlight hands a moving pellet to the first cell it touches and
+// reports whether it was eaten.
+func (s *State) feedFromFlight(f *Food) bool {
+	s.cellHits = s.grid.queryCells(s.cellHits[:0], f.X, f.Y, f.Radius)
+	for _, hit := range s.cellHits {
+		if s.canEatFood(hit.p.ID, hit.c, f) {
+			s.eatFood(hit.c, f)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,152 @@
+package game
+
+import "math"
+
+// FoodKind is one pellet type world food is drawn from.
+type FoodKind struct {
+	// Weight is the relative chance of spawning this kind.
+	Weight float32
+	// Value is the radius a cell gains by eating the pellet.
+	Value float32
+	// Radius is the pellet's own radius.
+	Radius float32
+}
+
+// FoodHotspot is a disc where food spawns more often.
+type FoodHotspot struct {
+	X      float32
+	Y      float32
+	Radius float32
+	// Weight is the relative chance that a pellet spawns in this hotspot
+	// rather than elsewhere; see Rules.FoodBackgroundWeight.
+	Weight float32
+}
+
+// foodTarget is how many world pellets the arena should hold: the base
+// food count plus FoodPerPlayer for every live player, kept within
+// FoodMin and FoodMax (0 = no maximum).
+func (s *State) foodTarget() int {
+	alive := 0
+	for _, p := range s.order {
+		if p.Status == StatusAlive {
+			alive++
+		}
+	}
+	return s.foodTargetFor(alive)
+}
+
+// foodTargetFor is foodTarget with alive live players.
+func (s *State) foodTargetFor(alive int) int {
+	target := s.foodCount + int(s.rules.FoodPerPlayer*float32(alive))
+	if s.rules.FoodMax > 0 {
+		target = min(target, s.rules.FoodMax)
+	}
+	return max(target, s.rules.FoodMin)
+}
+
+// FoodTarget returns the number of world pellets the arena is heading for.
+func (s *State) FoodTarget() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.foodTarget()
+}
+
+// refillFood spawns up to FoodSpawnRate world pellets (all of them when 0)
+// towards foodTarget. Surplus food is not removed; it is simply not
+// replaced once eaten.
+func (s *State) refillFood() {
+	missing := s.foodTarget() - s.worldFood
+	if s.rules.FoodSpawnRate > 0 {
+		missing = min(missing, s.rules.FoodSpawnRate)
+	}
+	for ; missing > 0; missing-- {
+		s.spawnFood()
+	}
+}
+
+// defaultFoodKind is the pellet used when Rules.FoodKinds is empty.
+var defaultFoodKind = FoodKind{Weight: 1, Value: 0.15, Radius: 0.35}
+
+func (s *State) spawnFood() {
+	kind := s.pickFoodKind()
+	x, y := s.foodPoint(kind.Radius)
+	s.addFood(&Food{
+		ID:     s.newFoodID(),
+		X:      x,
+		Y:      y,
+		Value:  kind.Value,
+		Radius: kind.Radius,
+		Owner:  NoOwner,
+	})
+}
+
+// pickFoodKind draws a pellet type from Rules.FoodKinds by weight.
+func (s *State) pickFoodKind() FoodKind {
+	kinds := s.rules.FoodKinds
+	var total float32
+	for _, k := range kinds {
+		total += max(k.Weight, 0)
+	}
+	if total <= 0 {
+		return defaultFoodKind
+	}
+	pick := s.rng.Float32() * total
+	for _, k := range kinds {
+		if pick -= max(k.Weight, 0); pick < 0 {
+			return k
+		}
+	}
+	return kinds[len(kinds)-1]
+}
+
+// foodPoint places a pellet of radius r clear of the map geometry. Pellets
+// come in clusters of FoodClusterSize around a shared centre; each centre
+// lies in a hotspot or, with FoodBackgroundWeight, anywhere in the arena.
+func (s *State) foodPoint(r float32) (x, y float32) {
+	for i := 0; i < openPointAttempts; i++ {
+		if s.clusterLeft <= 0 {
+			s.clusterX, s.clusterY = s.foodCentre()
+			s.clusterLeft = max(s.rules.FoodClusterSize, 1)
+		}
+		x, y = s.clusterX, s.clusterY
+		if s.rules.FoodClusterSize > 1 {
+			x, y = s.randInDisc(x, y, s.rules.FoodClusterSpread)
+		}
+		if s.arena.empty() || !s.arena.blocked(x, y, r) {
+			break
+		}
+		s.clusterLeft = 0 // the centre may be inside an obstacle: move on
+	}
+	s.clusterLeft--
+	return x, y
+}
+
+// foodCentre picks where the next pellet or cluster goes.
+func (s *State) foodCentre() (x, y float32) {
+	total := max(s.rules.FoodBackgroundWeight, 0)
+	for _, h := range s.rules.FoodHotspots {
+		total += max(h.Weight, 0)
+	}
+	if len(s.rules.FoodHotspots) > 0 && total > 0 {
+		pick := s.rng.Float32()*total - max(s.rules.FoodBackgroundWeight, 0)
+		for _, h := range s.rules.FoodHotspots {
+			if pick < 0 {
+				break
+			}
+			if pick -= max(h.Weight, 0); pick < 0 {
+				return s.randInDisc(h.X, h.Y, h.Radius)
+			}
+		}
+	}
+	return s.randInRange(), s.randInRange()
+}
+
+// randInDisc returns a uniformly distributed point within radius r of
+// (x, y), clamped to the arena.
+func (s *State) randInDisc(x, y, r float32) (float32, float32) {
+	d := r * float32(math.Sqrt(s.rng.Float64()))
+	a := 2 * math.Pi * s.rng.Float64()
+	x += d * float32(math.Cos(a))
+	y += d * float32(math.Sin(a))
+	return clamp(x, -s.arenaHalf, s.arenaHalf), clamp(y, -s.arenaHalf, s.arenaHalf)
+}
@@ -0,0 +1,147 @@
+package game
+
+import "math"
+
+// gridCellSize is the edge length of one spatial grid bucket in world units.
+const gridCellSize = 8
+
+type gridKey struct{ x, y int32 }
+
+// cellRef ties an indexed cell back to the player that owns it.
+type cellRef struct {
+	p *Player
+	c *Cell
+}
+
+// spatialGrid is a uniform hash grid over the arena. Food is indexed
+// incrementally as it is spawned, eaten or moved; cells move every tick and
+// are re-indexed in bulk with indexCells. Entities are bucketed by centre,
+// so queries widen their range by the largest radius indexed.
+type spatialGrid struct {
+	size   float32
+	lo, hi gridKey // bucket range covering the arena
+
+	foods         map[gridKey][]*Food
+	cells         map[gridKey][]cellRef
+	cellKeys      []gridKey // buckets indexCells filled last time
+	maxFoodRadius float32
+	maxCellRadius float32
+}
+
+func newSpatialGrid(size, arenaHalf float32) *spatialGrid {
+	g := &spatialGrid{
+		size:  size,
+		foods: make(map[gridKey][]*Food),
+		cells: make(map[gridKey][]cellRef),
+	}
+	g.lo, g.hi = g.key(-arenaHalf, -arenaHalf), g.key(arenaHalf, arenaHalf)
+	return g
+}
+
+func (g *spatialGrid) key(x, y float32) gridKey {
+	return gridKey{int32(math.Floor(float64(x / g.size))), int32(math.Floor(float64(y / g.size)))}
+}
+
+// span returns the bucket range covered by a square of half-size r around
+// (x, y), clipped to the arena so huge cells cost at most one full scan.
+func (g *spatialGrid) span(x, y, r float32) (lo, hi gridKey) {
+	lo, hi = g.key(x-r, y-r), g.key(x+r, y+r)
+	lo.x, lo.y = max(lo.x, g.lo.x), max(lo.y, g.lo.y)
+	hi.x, hi.y = min(hi.x, g.hi.x), min(hi.y, g.hi.y)
+	return lo, hi
+}
+
+func (g *spatialGrid) insertFood(f *Food) {
+	k := g.key(f.X, f.Y)
+	g.foods[k] = append(g.foods[k], f)
+	if f.Radius > g.maxFoodRadius {
+		g.maxFoodRadius = f.Radius
+	}
+}
+
+// removeFood drops f from the bucket at (x, y), which must be where f was
+// last indexed.
+func (g *spatialGrid) removeFood(f *Food, x, y float32) {
+	k := g.key(x, y)
+	bucket := g.foods[k]
+	for i, bf := range bucket {
+		if bf == f {
+			bucket[i] = bucket[len(bucket)-1]
+			bucket[len(bucket)-1] = nil
+			bucket = bucket[:len(bucket)-1]
+			break
+		}
+	}
+	if len(bucket) == 0 {
+		delete(g.foods, k)
+	} else {
+		g.foods[k] = bucket
+	}
+}
+
+// moveFood re-buckets f after it moved from (oldX, oldY).
+func (g *spatialGrid) moveFood(f *Food, oldX, oldY float32) {
+	if g.key(oldX, oldY) == g.key(f.X, f.Y) {
+		return
+	}
+	g.removeFood(f, oldX, oldY)
+	g.insertFood(f)
+}
+
+// queryFoods appends to dst every food whose centre lies in a bucket that a
+// circle of radius r around (x, y) could touch, widened by the largest food
+// radius. Callers still run the exact collision test.
+func (g *spatialGrid) queryFoods(dst []*Food, x, y, r float32) []*Food {
+	lo, hi := g.span(x, y, r+g.maxFoodRadius)
+	for kx := lo.x; kx <= hi.x; kx++ {
+		for ky := lo.y; ky <= hi.y; ky++ {
+			dst = append(dst, g.foods[gridKey{kx, ky}]...)
+		}
+	}
+	return dst
+}
+
+// indexCells rebuilds the cell index from scratch. Players are indexed in
+// the given order so bucket contents are reproducible.
+func (g *spatialGrid) indexCells(players []*Player) {
+	// only clear the buckets that hold cells; touching every bucket ever
+	// used would make the cost grow with how much of the arena cells visited
+	for _, k := range g.cellKeys {
+		bucket := g.cells[k]
+		clear(bucket)
+		g.cells[k] = bucket[:0]
+	}
+	g.cellKeys = g.cellKeys[:0]
+	g.maxCellRadius = 0
+	for _, p := range players {
+		for _, c := range p.Cells {
+			k := g.key(c.X, c.Y)
+			if len(g.cells[k]) == 0 {
+				g.cellKeys = append(g.cellKeys, k)
+			}
+			g.cells[k] = append(g.cells[k], cellRef{p, c})
+			if c.Radius > g.maxCellRadius {
+				g.maxCellRadius = c.Radius
+			}
+		}
+	}
+}
+
+// cellGrew keeps the query margin valid when an indexed cell grows between
+// two indexCells calls.
+func (g *spatialGrid) cellGrew(c *Cell) {
+	if c.Radius > g.maxCellRadius {
+		g.maxCellRadius = c.Radius
+	}
+}
+
+// queryCells is the cell counterpart of queryFoods.
+func (g *spatialGrid) queryCells(dst []cellRef, x, y, r float32) []cellRef {
+	lo, hi := g.span(x, y, r+g.maxCellRadius)
+	for kx := lo.x; kx <= hi.x; kx++ {
+		for ky := lo.y; ky <= hi.y; ky++ {
+			dst = append(dst, g.cells[gridKey{kx, ky}]...)
+		}
+	}
+	return dst
+}
@@ -0,0 +1,64 @@
+package game
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// benchRules keeps every cell at its spawn size so tick time depends on the
+// food and player counts alone: pellets are worth nothing, nothing decays,
+// there are no viruses or power-ups to pop or boost cells, and players
+// cannot eat each other.
+func benchRules() Rules {
+	rules := DefaultRules()
+	rules.RoundTicks = 0
+	rules.BotCount = 0
+	rules.FoodKinds = []FoodKind{{Weight: 1, Value: 0, Radius: defaultFoodKind.Radius}}
+	rules.DecayRate = 0
+	rules.VirusCount, rules.VirusMaxCount = 0, 0
+	rules.PowerUpCount = 0
+	rules.EatRatio = math.MaxFloat32
+	return rules
+}
+
+// BenchmarkTick measures one BallBattleLogic tick at several food and
+// player counts. Every player picks a new random direction every 30 ticks.
+func BenchmarkTick(b *testing.B) {
+	for _, foods := range []int{120, 500, 2000} {
+		for _, players := range []int{1, 10, 100} {
+			b.Run(fmt.Sprintf("foods=%d/players=%d", foods, players), func(b *testing.B) {
+				benchmarkTick(b, foods, players)
+			})
+		}
+	}
+}
+
+func benchmarkTick(b *testing.B, foods, players int) {
+	state := NewState(100, foods, benchRules(), 1, nil)
+	logic := NewBallBattleLogic(state)
+	for i := 0; i < players; i++ {
+		state.AddPlayer(uint16(i + 1))
+	}
+	rng := rand.New(rand.NewSource(1))
+	inputs := make(map[uint16]uint32, players)
+
+	b.ResetTimer()
+	for t := 1; t <= b.N; t++ {
+		if t%30 == 1 {
+			for i := 0; i < players; i++ {
+				a := rng.Float64() * 2 * math.Pi
+				inputs[uint16(i+1)] = EncodeInput(float32(math.Cos(a)), float32(math.Sin(a)), 0)
+			}
+		}
+		logic.Tick(uint32(t), inputs)
+	}
+	b.StopTimer()
+
+	for _, p := range state.Players {
+		if len(p.Cells) != 1 || p.Cells[0].Radius != startRadius {
+			b.Fatalf("player %d changed size: %d cells", p.ID, len(p.Cells))
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package game
+
+import "math"
+
+// Vector input layout. Inputs with the InputVector bit set carry a full 2D
+// direction instead of one of the legacy InputLeft..InputEject values:
+//
+//	bits 0-7   angle, 256 steps counter-clockwise from +X
+//	bits 8-15  magnitude, 0..255 mapped to 0..1
+//	bit  16    InputActionSplit
+//	bit  17    InputActionEject
+//	bit  18    InputActionRespawn
+//	bit  31    InputVector
+const (
+	InputActionSplit   uint32 = 1 << 16
+	InputActionEject   uint32 = 1 << 17
+	InputActionRespawn uint32 = 1 << 18
+	InputVector        uint32 = 1 << 31
+
+	inputActionMask = InputActionSplit | InputActionEject | InputActionRespawn
+
+	inputAngleSteps = 256
+	inputMagMax     = 255
+)
+
+// EncodeInput packs a direction and action bits into a vector input. The
+// direction is clamped to unit length. Returns InputNone when there is
+// nothing to send.
+func EncodeInput(dx, dy float32, actions uint32) uint32 {
+	mag := math.Hypot(float64(dx), float64(dy))
+	if mag > 1 {
+		mag = 1
+	}
+	m := uint32(math.Round(mag * inputMagMax))
+	if m == 0 && actions == 0 {
+		return InputNone
+	}
+	var a uint32
+	if m > 0 {
+		angle := math.Atan2(float64(dy), float64(dx))
+		if angle < 0 {
+			angle += 2 * math.Pi
+		}
+		a = uint32(math.Round(angle/(2*math.Pi)*inputAngleSteps)) % inputAngleSteps
+	}
+	return InputVector | a | m<<8 | actions&inputActionMask
+}
+
+// DecodeInput unpacks an input into a direction of at most unit length and
+// its action bits. Legacy enum values are translated to the same form.
+func DecodeInput(input uint32) (dx, dy float32, actions uint32) {
+	if input&InputVector == 0 {
+		switch input {
+		case InputLeft:
+			return -1, 0, 0
+		case InputRight:
+			return 1, 0, 0
+		case InputUp:
+			return 0, 1, 0
+		case InputDown:
+			return 0, -1, 0
+		case InputSplit:
+			return 0, 0, InputActionSplit
+		case InputEject:
+			return 0, 0, InputActionEject
+		case InputRespawn:
+			return 0, 0, InputActionRespawn
+		}
+		return 0, 0, 0
+	}
+	a := float64(input&0xFF) / inputAngleSteps * 2 * math.Pi
+	mag := float64(input>>8&0xFF) / inputMagMax
+	return float32(math.Cos(a) * mag), float32(math.Sin(a) * mag), input & inputActionMask
+}
@@ -0,0 +1,25 @@
+package game
+
+// focus is the centre and size of a player's view: the plain average of
+// its cell centres, which is where the client puts its camera, and the
+// radius of a single cell holding all its mass.
+type focus struct {
+	x, y, size float32
+}
+
+func focusOf(p *Player) focus {
+	var fc focus
+	for _, c := range p.Cells {
+		fc.x += c.X
+		fc.y += c.Y
+	}
+	n := float32(len(p.Cells))
+	fc.x, fc.y = fc.x/n, fc.y/n
+	fc.size = massToRadius(p.Mass())
+	return fc
+}
+
+// interestRadius is how far from its focus a player is sent entities.
+func (r *Rules) interestRadius(fc focus) float32 {
+	return r.InterestRadius + r.InterestScale*fc.size
+}
@@ -0,0 +1,54 @@
+package game
+
+// PlayerStatus is where a player is in its life cycle.
+type PlayerStatus uint8
+
+const (
+	// StatusAlive players own at least one cell.
+	StatusAlive PlayerStatus = iota
+	// StatusDead players own no cells and wait to respawn.
+	StatusDead
+)
+
+// respawn brings p back to life with a single start-size cell.
+func (s *State) respawn(p *Player) {
+	x, y := s.spawnPoint(startRadius)
+	p.Cells = []*Cell{s.newCell(x, y, startRadius)}
+	p.Status = StatusAlive
+	p.InputX, p.InputY = 0, 0
+	p.Effects = [PowerUpKinds]uint32{}
+}
+
+// kill marks p dead after its last cell was eaten by killer.
+func (s *State) kill(p *Player, killer uint16) {
+	p.Cells = nil
+	p.Status = StatusDead
+	p.KilledBy = killer
+	p.DiedAt = s.tick
+}
+
+// canRespawn reports whether a dead player's cooldown has passed. Nobody
+// respawns while a battle-royale zone is active.
+func (s *State) canRespawn(p *Player) bool {
+	return p.Status == StatusDead && !s.zone.active && s.tick-p.DiedAt >= s.rules.RespawnCooldown
+}
+
+// respawnIn returns how many ticks remain before dead player p may respawn.
+func (s *State) respawnIn(p *Player) uint32 {
+	if p.Status != StatusDead || s.tick-p.DiedAt >= s.rules.RespawnCooldown {
+		return 0
+	}
+	return s.rules.RespawnCooldown - (s.tick - p.DiedAt)
+}
+
+// autoRespawn revives dead players that have waited RespawnAutoTicks.
+func (s *State) autoRespawn() {
+	if s.rules.RespawnAutoTicks == 0 || s.zone.active {
+		return
+	}
+	for _, p := range s.order {
+		if p.Status == StatusDead && s.tick-p.DiedAt >= s.rules.RespawnAutoTicks {
+			s.respawn(p)
+		}
+	}
+}
@@ -1,28 +1,105 @@
 package game
 
 import (
-	"encoding/binary"
-	"bytes"
+	"maps"
 	"net"
+	"slices"
+	"sync"
+
+	"ballbattle/internal/snapshot"
 )
 
 // BallBattleLogic 实现 gameframework 的 GameLogic 接口
 type BallBattleLogic struct {
-	state *State
+	state  *State
+	rounds *RoundManager
+
+	// 服务器托管的机器人：真人玩家不足 BotCount 时补充，真人加入后移除
+	botsMu sync.Mutex
+	bots   []*bot // 按 ID 排序
+	humans map[uint16]bool
+
+	// 增量快照：当前 tick 的编码结果，以及每个客户端已发送/已确认的快照历史
+	frameMu sync.Mutex
+	frame   *frame
+	viewsMu sync.Mutex
+	views   map[uint16]*clientView
 }
 
 func NewBallBattleLogic(state *State) *BallBattleLogic {
-	return &BallBattleLogic{state: state}
+	l := &BallBattleLogic{
+		state:  state,
+		rounds: NewRoundManager(state, state.rules),
+		humans: make(map[uint16]bool),
+		views:  make(map[uint16]*clientView),
+	}
+	l.balanceBots()
+	return l
 }
 
 // OnJoin 玩家加入时初始化
 func (l *BallBattleLogic) OnJoin(pid uint16) {
+	// 客户端的 -id 可以是任意 uint16，真人选中机器人正在使用的 ID 时先移除该机器人，
+	// 否则 Tick 会用机器人的输入覆盖真人的输入，balanceBots 也可能把真人当作机器人移除；
+	// 随后 balanceBots 用另一个空闲 ID 补上机器人
+	l.botsMu.Lock()
+	n := len(l.bots)
+	l.bots = slices.DeleteFunc(l.bots, func(b *bot) bool { return b.id == pid })
+	if len(l.bots) < n {
+		l.state.RemovePlayer(pid)
+	}
+	l.humans[pid] = true
+	l.botsMu.Unlock()
 	l.state.AddPlayer(pid)
+	l.resetView(pid)
+	l.balanceBots()
 }
 
 // OnLeave 玩家离开（预留）
 func (l *BallBattleLogic) OnLeave(pid uint16) {
 	l.state.RemovePlayer(pid)
+	l.resetView(pid)
+	l.botsMu.Lock()
+	delete(l.humans, pid)
+	l.botsMu.Unlock()
+	l.balanceBots()
+}
+
+// resetView 丢弃 pid 的快照历史，下一个快照将是完整快照
+func (l *BallBattleLogic) resetView(pid uint16) {
+	l.viewsMu.Lock()
+	delete(l.views, pid)
+	l.viewsMu.Unlock()
+}
+
+// balanceBots 增减机器人，使真人加机器人的总数保持在 BotCount；
+// 真人已经达到 BotCount 时不再有机器人。新机器人使用最小的空闲 ID，移除时先移除 ID 最大的
+func (l *BallBattleLogic) balanceBots() {
+	l.botsMu.Lock()
+	defer l.botsMu.Unlock()
+	want := max(l.state.rules.BotCount-len(l.humans), 0)
+	for len(l.bots) > want {
+		b := l.bots[len(l.bots)-1]
+		l.bots = l.bots[:len(l.bots)-1]
+		l.state.RemovePlayer(b.id)
+	}
+	for id := BotIDBase; len(l.bots) < want && IsBot(id); id++ {
+		i := len(l.bots)
+		for j, b := range l.bots {
+			if b.id >= id {
+				i = j
+				break
+			}
+		}
+		if i < len(l.bots) && l.bots[i].id == id {
+			continue
+		}
+		if l.humans[id] {
+			continue
+		}
+		l.state.AddPlayer(id)
+		l.bots = slices.Insert(l.bots, i, l.state.newBot(id))
+	}
 }
 
 // ApplyInput 收到输入时立即应用（用于即时反馈，可选）
@@ -32,40 +109,108 @@ func (l *BallBattleLogic) ApplyInput(pid uint16, input uint32) {
 
 // Tick 每个 tick 调用，应用所有输入并更新游戏状态
 func (l *BallBattleLogic) Tick(tick uint32, inputs map[uint16]uint32) {
-	for pid, input := range inputs {
-		if input != 0 { // 只处理非零输入（InputNone=0 不需要处理）
-			l.state.ApplyInput(pid, input)
-		}
+	// 热身和结算阶段世界冻结，不应用输入
+	if simulate, _ := l.rounds.Advance(tick); !simulate {
+		return
+	}
+	// 机器人的输入与真人输入合并后一起按 ID 顺序应用，不修改框架传入的 map
+	l.botsMu.Lock()
+	if len(l.bots) > 0 {
+		merged := make(map[uint16]uint32, len(inputs)+len(l.bots))
+		maps.Copy(merged, inputs)
+		l.state.botInputs(l.bots, merged)
+		inputs = merged
 	}
+	l.botsMu.Unlock()
+	// InputNone 也要应用：它表示玩家松开了方向键，球会在摩擦力作用下减速
+	// 按玩家 ID 顺序应用，保证同样的种子和输入得到同样的结果
+	for _, pid := range slices.Sorted(maps.Keys(inputs)) {
+		l.state.ApplyInput(pid, inputs[pid])
+	}
+	// 每个玩家每个 tick 都要积分速度，而不仅是有输入的玩家
+	l.state.Step(tick)
 }
 
-// Snapshot 返回当前状态的二进制快照
-// 格式: uint8 playerCount, [pid(uint16), x(float32), y(float32), radius(float32)]*N,
-//       uint16 foodCount, [id(uint32), x(float32), y(float32), value(float32), radius(float32)]*M
+// Snapshot 返回当前状态的完整二进制快照（snapshot.KindFull），不依赖任何基线
+// 格式由 internal/snapshot 定义，服务端与客户端共用；开头的版本号不一致时客户端拒绝解码
 func (l *BallBattleLogic) Snapshot(tick uint32) ([]byte, error) {
-	snap := l.state.Snapshot()
-	buf := &bytes.Buffer{}
-	
-	// players section
-	binary.Write(buf, binary.LittleEndian, uint8(len(snap.Players)))
-	for _, p := range snap.Players {
-		binary.Write(buf, binary.LittleEndian, p.ID)
-		binary.Write(buf, binary.LittleEndian, p.X)
-		binary.Write(buf, binary.LittleEndian, p.Y)
-		binary.Write(buf, binary.LittleEndian, p.Radius)
+	return l.frameAt(tick).AppendFull(nil), nil
+}
+
+// MaxSnapshotSize 返回最多 players 个玩家（含机器人）时 Snapshot 的最大长度。
+// 玩家吐出的食物数量不受规则限制，不计算在内
+func (l *BallBattleLogic) MaxSnapshotSize(players int) int {
+	rules := &l.state.rules
+	teams := 0
+	if rules.Mode == ModeTeams {
+		teams = rules.Teams
+	}
+	return snapshot.MaxFullSize(players, players*max(rules.MaxCells, 1), l.state.foodTargetFor(players),
+		max(rules.VirusCount, rules.VirusMaxCount), rules.PowerUpCount, teams)
+}
+
+// SnapshotFor 返回发给 pid 的快照：只包含该玩家兴趣范围内的实体（见 interest.go），
+// 以该客户端最近确认（且仍在历史中）的快照为基线编码增量（snapshot.KindDelta），
+// 没有可用基线或增量不更小时发送完整快照。由服务器的快照通道每 tick 调用（见 internal/server）
+func (l *BallBattleLogic) SnapshotFor(pid uint16, tick uint32) ([]byte, error) {
+	f := l.frameAt(tick)
+	sf := f.Frame
+	l.viewsMu.Lock()
+	v := l.views[pid]
+	if v == nil {
+		v = newClientView()
+		l.views[pid] = v
+	}
+	// 兴趣范围以玩家的镜头为中心，随玩家大小扩大；死亡后沿用最后的位置，从未出生过则发送整个世界
+	if fc, ok := f.focus[pid]; ok {
+		v.focus, v.hasFocus = fc, true
+	}
+	if rules := &l.state.rules; rules.InterestRadius > 0 && v.hasFocus {
+		sf = sf.Within(pid, v.focus.x, v.focus.y, rules.interestRadius(v.focus))
+	}
+	base := v.sent.Baseline()
+	v.sent.Record(sf)
+	l.viewsMu.Unlock()
+
+	// 变化太多（例如大量食物被吃掉后重新生成）时增量可能比完整快照还大，此时发送完整快照
+	if base != nil {
+		if out := sf.AppendDelta(nil, base); len(out) < sf.FullSize() {
+			return out, nil
+		}
 	}
-	
-	// foods section
-	binary.Write(buf, binary.LittleEndian, uint16(len(snap.Foods)))
-	for _, f := range snap.Foods {
-		binary.Write(buf, binary.LittleEndian, f.ID)
-		binary.Write(buf, binary.LittleEndian, f.X)
-		binary.Write(buf, binary.LittleEndian, f.Y)
-		binary.Write(buf, binary.LittleEndian, f.Value)
-		binary.Write(buf, binary.LittleEndian, f.Radius)
+	return sf.AppendFull(nil), nil
+}
+
+// AckSnapshots 记录 pid 已收到的快照：ack 为最新收到的 tick，
+// ackBits 的第 i 位表示 tick ack-1-i 也已收到（与 UDP 头部的 ack/ackbits 规则相同）
+func (l *BallBattleLogic) AckSnapshots(pid uint16, ack, ackBits uint32) {
+	l.viewsMu.Lock()
+	defer l.viewsMu.Unlock()
+	if v := l.views[pid]; v != nil {
+		v.sent.Ack(ack, ackBits)
+	}
+}
+
+// Round 返回当前回合信息。服务器的快照通道在每个 tick 前后比较它，
+// 阶段变化时把公告（MsgRoundEvent）作为事件发给所有玩家（见 internal/server）
+func (l *BallBattleLogic) Round() RoundInfo {
+	return l.rounds.Info()
+}
+
+// MapMessage 返回地图几何消息（MsgMapGeometry）。地图是静态的，不放进快照，
+// 由服务器的快照通道作为事件发给每个玩家一次（见 internal/server）
+func (l *BallBattleLogic) MapMessage() []byte {
+	return EncodeMap(l.state.arenaHalf, l.state.arena)
+}
+
+// frameAt 返回 tick 的快照记录，每个 tick 只编码一次，所有客户端共用
+func (l *BallBattleLogic) frameAt(tick uint32) *frame {
+	l.frameMu.Lock()
+	defer l.frameMu.Unlock()
+	if l.frame == nil || l.frame.Tick() != tick {
+		l.frame = encodeFrame(tick, l.state.Snapshot(), l.rounds.Info())
 	}
-	
-	return buf.Bytes(), nil
+	return l.frame
 }
 
 // HandleReliableMessage 处理可靠消息
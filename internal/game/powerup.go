@@ -0,0 +1,121 @@
+package game
+
+import "math"
+
+// PowerUpKind identifies a power-up effect. It doubles as the index into
+// Player.Effects.
+type PowerUpKind uint8
+
+const (
+	// PowerSpeed multiplies the player's top speed by SpeedBoost.
+	PowerSpeed PowerUpKind = iota
+	// PowerShield makes the player's cells impossible to eat.
+	PowerShield
+	// PowerMagnet pulls nearby food towards the player's cells.
+	PowerMagnet
+
+	// PowerUpKinds is the number of power-up kinds.
+	PowerUpKinds
+)
+
+// powerUpRadius is the pickup radius of a power-up.
+const powerUpRadius = 1.0
+
+// PowerUp is a pickup lying in the arena. The first cell to touch it grants
+// its player the effect for PowerUpTicks.
+type PowerUp struct {
+	ID   uint32
+	Kind PowerUpKind
+	X    float32
+	Y    float32
+}
+
+func (s *State) spawnPowerUp() {
+	s.nextPowerUp++
+	kind := PowerUpKind(s.rng.Intn(int(PowerUpKinds)))
+	x, y := s.openPoint(powerUpRadius)
+	s.PowerUps = append(s.PowerUps, &PowerUp{ID: s.nextPowerUp, Kind: kind, X: x, Y: y})
+}
+
+// has reports whether p currently enjoys the given effect.
+func (p *Player) has(kind PowerUpKind) bool {
+	return p.Effects[kind] > 0
+}
+
+// speedBoost is the top-speed multiplier of p.
+func (s *State) speedBoost(p *Player) float32 {
+	if p.has(PowerSpeed) {
+		return s.rules.SpeedBoost
+	}
+	return 1
+}
+
+// tickEffects counts down every active effect by one tick.
+func (s *State) tickEffects() {
+	for _, p := range s.order {
+		for k, left := range p.Effects {
+			if left > 0 {
+				p.Effects[k] = left - 1
+			}
+		}
+	}
+}
+
+// pickUpPowerUps hands every power-up touched by a cell to that cell's
+// player and replaces it elsewhere in the arena.
+func (s *State) pickUpPowerUps() {
+	taken := 0
+	kept := s.PowerUps[:0]
+	for _, pu := range s.PowerUps {
+		if p := s.firstToucher(pu); p != nil {
+			p.Effects[pu.Kind] = s.rules.PowerUpTicks
+			taken++
+			continue
+		}
+		kept = append(kept, pu)
+	}
+	clear(s.PowerUps[len(kept):])
+	s.PowerUps = kept
+	for ; taken > 0; taken-- {
+		s.spawnPowerUp()
+	}
+}
+
+// firstToucher returns the player of the first indexed cell touching pu.
+func (s *State) firstToucher(pu *PowerUp) *Player {
+	s.cellHits = s.grid.queryCells(s.cellHits[:0], pu.X, pu.Y, powerUpRadius)
+	for _, hit := range s.cellHits {
+		if collide(hit.c.X, hit.c.Y, hit.c.Radius, pu.X, pu.Y, powerUpRadius) {
+			return hit.p
+		}
+	}
+	return nil
+}
+
+// pullFood drags food within MagnetRadius of a magnetised cell's edge
+// MagnetPull units towards it. Pellets still flying are left alone.
+func (s *State) pullFood() {
+	for _, p := range s.order {
+		if !p.has(PowerMagnet) {
+			continue
+		}
+		for _, c := range p.Cells {
+			s.foodHits = s.grid.queryFoods(s.foodHits[:0], c.X, c.Y, c.Radius+s.rules.MagnetRadius)
+			for _, f := range s.foodHits {
+				if f.VX != 0 || f.VY != 0 {
+					continue
+				}
+				dx, dy := c.X-f.X, c.Y-f.Y
+				d := float32(math.Hypot(float64(dx), float64(dy)))
+				if d == 0 || d > c.Radius+s.rules.MagnetRadius {
+					continue
+				}
+				step := min(s.rules.MagnetPull, d)
+				ox, oy := f.X, f.Y
+				f.X += dx / d * step
+				f.Y += dy / d * step
+				s.grid.moveFood(f, ox, oy)
+			}
+		}
+	}
+}
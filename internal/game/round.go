@@ -0,0 +1,235 @@
+package game
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// RoundPhase is where the match is in its round cycle.
+type RoundPhase uint8
+
+const (
+	// PhaseWarmup is the countdown before a round: the arena has just been
+	// reset and the world is frozen.
+	PhaseWarmup RoundPhase = iota
+	// PhaseRunning is normal play. With Rules.RoundTicks == 0 the match
+	// stays in this phase forever. In ModeRoyale it ends early once a
+	// single player is left.
+	PhaseRunning
+	// PhaseEnded freezes the final board so everyone can see the winner.
+	PhaseEnded
+	// PhaseIntermission lets players roam between rounds, but nobody can
+	// eat anybody.
+	PhaseIntermission
+)
+
+func (p RoundPhase) String() string {
+	switch p {
+	case PhaseWarmup:
+		return "warmup"
+	case PhaseRunning:
+		return "running"
+	case PhaseEnded:
+		return "ended"
+	case PhaseIntermission:
+		return "intermission"
+	}
+	return fmt.Sprintf("RoundPhase(%d)", uint8(p))
+}
+
+// RoundInfo describes the current round. Winner, WinnerTeam and WinnerMass
+// refer to the last finished round; Winner is NoOwner until a round has been
+// won. In team mode WinnerTeam is the winning team, WinnerMass its summed
+// mass and Winner its heaviest member.
+type RoundInfo struct {
+	Phase      RoundPhase
+	Round      uint32
+	Remaining  uint32 // ticks left in the phase, 0 when it never ends
+	Winner     uint16
+	WinnerTeam uint8
+	WinnerMass float32
+}
+
+// MsgRoundEvent is the message type announcing a phase change, sent as an
+// event on the snapshot channel.
+// Game messages start at 0x80 to stay clear of the framework's own.
+const MsgRoundEvent byte = 0x80
+
+// EncodeRoundEvent builds a MsgRoundEvent payload:
+//
+//	msgType(uint8), phase(uint8), round(uint32), remaining(uint32), winner(uint16), winnerTeam(uint8), winnerMass(float32)
+func EncodeRoundEvent(info RoundInfo) []byte {
+	buf := &bytes.Buffer{}
+	buf.WriteByte(MsgRoundEvent)
+	writeRoundInfo(buf, info)
+	return buf.Bytes()
+}
+
+// DecodeRoundEvent parses a payload built by EncodeRoundEvent.
+func DecodeRoundEvent(payload []byte) (RoundInfo, error) {
+	if len(payload) == 0 || payload[0] != MsgRoundEvent {
+		return RoundInfo{}, fmt.Errorf("not a round event")
+	}
+	return ReadRoundInfo(bytes.NewReader(payload[1:]))
+}
+
+func writeRoundInfo(buf *bytes.Buffer, info RoundInfo) {
+	binary.Write(buf, binary.LittleEndian, uint8(info.Phase))
+	binary.Write(buf, binary.LittleEndian, info.Round)
+	binary.Write(buf, binary.LittleEndian, info.Remaining)
+	binary.Write(buf, binary.LittleEndian, info.Winner)
+	binary.Write(buf, binary.LittleEndian, info.WinnerTeam)
+	binary.Write(buf, binary.LittleEndian, info.WinnerMass)
+}
+
+// ReadRoundInfo reads the round section shared by snapshots and round events.
+func ReadRoundInfo(r *bytes.Reader) (RoundInfo, error) {
+	var info RoundInfo
+	var phase uint8
+	if err := binary.Read(r, binary.LittleEndian, &phase); err != nil {
+		return info, err
+	}
+	info.Phase = RoundPhase(phase)
+	binary.Read(r, binary.LittleEndian, &info.Round)
+	binary.Read(r, binary.LittleEndian, &info.Remaining)
+	binary.Read(r, binary.LittleEndian, &info.Winner)
+	binary.Read(r, binary.LittleEndian, &info.WinnerTeam)
+	err := binary.Read(r, binary.LittleEndian, &info.WinnerMass)
+	return info, err
+}
+
+// RoundManager drives the warmup, running, ended and intermission cycle on
+// top of a State. It is advanced once per tick by BallBattleLogic.
+type RoundManager struct {
+	state  *State
+	rules  Rules
+	info   RoundInfo
+	endsAt uint32 // tick at which the current phase ends
+	begun  bool
+}
+
+func NewRoundManager(state *State, rules Rules) *RoundManager {
+	return &RoundManager{state: state, rules: rules, info: RoundInfo{Winner: NoOwner}}
+}
+
+// Advance moves the round clock to tick. It reports whether the world should
+// be simulated this tick and whether the phase changed.
+func (m *RoundManager) Advance(tick uint32) (simulate, changed bool) {
+	if !m.begun {
+		m.begun = true
+		if m.rules.RoundTicks == 0 {
+			m.info.Phase = PhaseRunning
+		} else {
+			m.enter(PhaseWarmup, tick)
+		}
+		changed = true
+	}
+	if m.rules.RoundTicks == 0 {
+		return true, changed
+	}
+	if m.info.Phase == PhaseRunning && m.rules.Mode == ModeRoyale && m.state.LastStanding() {
+		m.endsAt = tick
+	}
+	for tick >= m.endsAt {
+		switch m.info.Phase {
+		case PhaseWarmup:
+			m.enter(PhaseRunning, m.endsAt)
+		case PhaseRunning:
+			m.info.Winner, m.info.WinnerTeam, m.info.WinnerMass = m.state.Leader()
+			m.enter(PhaseEnded, m.endsAt)
+		case PhaseEnded:
+			m.enter(PhaseIntermission, m.endsAt)
+		case PhaseIntermission:
+			m.enter(PhaseWarmup, m.endsAt)
+		}
+		changed = true
+	}
+	m.info.Remaining = m.endsAt - tick
+	return m.info.Phase == PhaseRunning || m.info.Phase == PhaseIntermission, changed
+}
+
+// enter switches to phase at tick start.
+func (m *RoundManager) enter(phase RoundPhase, start uint32) {
+	m.info.Phase = phase
+	var length uint32
+	switch phase {
+	case PhaseWarmup:
+		m.info.Round++
+		m.state.Reset()
+		length = m.rules.WarmupTicks
+	case PhaseRunning:
+		length = m.rules.RoundTicks
+		if m.rules.Mode == ModeRoyale {
+			m.state.StartZone(start)
+		}
+	case PhaseEnded:
+		length = m.rules.RoundEndTicks
+		m.state.StopZone()
+	case PhaseIntermission:
+		length = m.rules.IntermissionTicks
+	}
+	m.state.SetPeaceful(phase == PhaseIntermission)
+	m.endsAt = start + max(length, 1)
+}
+
+// Info returns the current round state.
+func (m *RoundManager) Info() RoundInfo {
+	return m.info
+}
+
+// Reset clears the arena for a new round: food, viruses and power-ups are
+// respawned from scratch and every player, dead or alive, restarts with a
+// single cell.
+func (s *State) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	clear(s.Foods)
+	s.worldFood = 0
+	s.Viruses = nil
+	s.PowerUps = nil
+	s.flying = nil
+	s.grid = newSpatialGrid(gridCellSize, s.arenaHalf)
+	s.populate()
+	for _, p := range s.order {
+		p.KilledBy, p.DiedAt = 0, 0
+		s.respawn(p)
+	}
+	s.grid.indexCells(s.order)
+}
+
+// SetPeaceful turns player-vs-player eating off or back on.
+func (s *State) SetPeaceful(peaceful bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.peaceful = peaceful
+}
+
+// Leader returns the player with the largest total mass, or NoOwner when
+// nobody is alive. Ties go to the lower ID. In team mode the team with the
+// largest summed mass leads (ties go to the lower team) and pid is its
+// heaviest member.
+func (s *State) Leader() (pid uint16, team uint8, mass float32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	team = NoTeam
+	for i, m := range s.teamMasses() {
+		if m > mass {
+			team, mass = uint8(i+1), m
+		}
+	}
+	pid = NoOwner
+	var best float32
+	for _, p := range s.order {
+		if p.Team != team {
+			continue
+		}
+		if m := p.Mass(); m > best {
+			pid, best = p.ID, m
+		}
+	}
+	if team == NoTeam {
+		mass = best
+	}
+	return pid, team, mass
+}
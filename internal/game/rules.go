@@ -0,0 +1,251 @@
+package game
+
+import (
+	"encoding/json"
+	"os"
+)
+
+// Rules holds the tunable gameplay parameters shared by State and
+// BallBattleLogic. It can be loaded from a JSON file (see LoadRules) so
+// different rule sets can be compared side by side.
+type Rules struct {
+	// Mode is the game mode: ModeFFA, ModeTeams or ModeRoyale.
+	Mode Mode
+	// Teams is how many teams players are split into in ModeTeams.
+	Teams int
+
+	// EatRatio is how many times larger (by radius) a ball must be than
+	// another before it can eat it.
+	EatRatio float32
+	// EatOverlap is the fraction (0..1) of the smaller ball's diameter that
+	// must be covered by the larger one. 1 means fully swallowed.
+	EatOverlap float32
+
+	// Acceleration is the fraction of a cell's top speed gained per tick
+	// while steering at full input.
+	Acceleration float32
+	// Friction is the fraction (0..1) of velocity a cell keeps each tick.
+	// With Acceleration == 1-Friction cells settle exactly at top speed.
+	Friction float32
+
+	// MaxCells caps how many cells a single player may own.
+	MaxCells int
+	// SplitMinRadius is the smallest cell radius that can still split.
+	SplitMinRadius float32
+	// SplitSpeed is the launch speed (units per tick) added to a freshly
+	// split cell; it bleeds off through Friction like any other velocity.
+	SplitSpeed float32
+	// MergeTicks is how long split cells stay apart before recombining.
+	MergeTicks uint32
+	// MergePull is the fraction of a cell's top speed it gains per tick
+	// towards its player's centre of mass once its merge timer expired, so
+	// siblings steered in parallel still come together.
+	MergePull float32
+
+	// EjectMinRadius is the smallest cell radius that can still eject mass.
+	EjectMinRadius float32
+	// EjectRadius is the radius a cell loses per ejection; the pellet is
+	// worth the same amount to whoever eats it.
+	EjectRadius float32
+	// EjectSpeed is the launch speed (units per tick) of an ejected pellet.
+	EjectSpeed float32
+	// EjectDecay scales the pellet speed every tick (0..1).
+	EjectDecay float32
+
+	// DecayThreshold is the cell radius above which mass starts to decay.
+	DecayThreshold float32
+	// DecayRate is the fraction of the radius above DecayThreshold a cell
+	// loses every tick. 0 disables decay.
+	DecayRate float32
+
+	// VirusCount is how many viruses the world keeps at least.
+	VirusCount int
+	// VirusMaxCount caps viruses including those shot by feeding.
+	VirusMaxCount int
+	// VirusRadius is the size of every virus. Only cells that can eat a
+	// ball of this size (see EatRatio) pop on contact.
+	VirusRadius float32
+	// VirusFeedCount is how many ejected pellets make a virus shoot a new one.
+	VirusFeedCount int
+	// VirusShootSpeed is the launch speed of a shot virus.
+	VirusShootSpeed float32
+	// VirusPopPieces is how many pieces a popped cell bursts into at most.
+	VirusPopPieces int
+	// VirusMassLoss is the fraction of mass a popped cell loses instead when
+	// it cannot split (MaxCells reached or MaxCells <= 1).
+	VirusMassLoss float32
+
+	// RespawnCooldown is how many ticks a dead player must wait before a
+	// respawn request is honoured.
+	RespawnCooldown uint32
+	// RespawnAutoTicks respawns dead players automatically after this many
+	// ticks. 0 means only on request.
+	RespawnAutoTicks uint32
+
+	// SpawnCandidates is how many random positions are tried when placing a
+	// new or respawning player.
+	SpawnCandidates int
+	// SpawnClearance is the minimum gap wanted between a spawned cell and
+	// any threatening cell. If no candidate achieves it the roomiest one is used.
+	SpawnClearance float32
+	// SpawnThreatRadius is the cell radius from which a cell counts as a
+	// threat when spawning. 0 means any cell big enough to eat the new one.
+	SpawnThreatRadius float32
+
+	// FoodPerPlayer is how many world pellets are added to the base food
+	// count (-foods) for every live player.
+	FoodPerPlayer float32
+	// FoodMin and FoodMax bound the food target. FoodMax 0 means no maximum.
+	FoodMin int
+	FoodMax int
+	// FoodSpawnRate caps how many pellets are spawned per tick while the
+	// arena is below its food target. 0 refills instantly.
+	FoodSpawnRate int
+
+	// FoodKinds are the pellet types world food is drawn from, by weight.
+	// Empty means a single kind worth 0.15 with radius 0.35.
+	FoodKinds []FoodKind
+	// FoodHotspots are discs where food spawns more often.
+	FoodHotspots []FoodHotspot
+	// FoodBackgroundWeight is the relative chance, against the hotspot
+	// weights, that food spawns uniformly anywhere in the arena.
+	FoodBackgroundWeight float32
+	// FoodClusterSize makes food spawn in clusters of this many pellets
+	// within FoodClusterSpread of a shared centre. 1 spawns them singly.
+	FoodClusterSize   int
+	FoodClusterSpread float32
+
+	// PowerUpCount is how many power-ups lie in the arena at any time.
+	PowerUpCount int
+	// PowerUpTicks is how long a picked-up effect lasts.
+	PowerUpTicks uint32
+	// SpeedBoost is the top-speed multiplier of PowerSpeed.
+	SpeedBoost float32
+	// MagnetRadius is how far beyond a cell's edge PowerMagnet reaches.
+	MagnetRadius float32
+	// MagnetPull is how far (units per tick) a magnet drags food.
+	MagnetPull float32
+
+	// BotCount is the player population server-side bots top up to: with
+	// fewer humans connected, bots fill the gap. 0 disables bots.
+	BotCount int
+	// BotDifficulty (0..1) makes bots react faster, see further, aim better
+	// and, from 0.5, split to catch prey.
+	BotDifficulty float32
+
+	// RoundTicks is the length of a round. 0 disables rounds: the match is
+	// one endless session.
+	RoundTicks uint32
+	// WarmupTicks is the frozen countdown after the arena is reset.
+	WarmupTicks uint32
+	// RoundEndTicks is how long the final board and winner stay on screen.
+	RoundEndTicks uint32
+	// IntermissionTicks is the free-roam break before the next warmup.
+	IntermissionTicks uint32
+
+	// ZoneWaitTicks is how long the ModeRoyale safe zone holds still before
+	// each shrink.
+	ZoneWaitTicks uint32
+	// ZoneShrinkTicks is how long each shrink takes.
+	ZoneShrinkTicks uint32
+	// ZoneShrinkFactor is the fraction of its radius the zone keeps per shrink.
+	ZoneShrinkFactor float32
+	// ZoneMinRadius is the final safe radius.
+	ZoneMinRadius float32
+	// ZoneDamage is the fraction of mass a cell outside the zone loses per tick.
+	ZoneDamage float32
+
+	// InterestRadius is how far around its camera a client is sent other
+	// entities; it should cover the client's view plus a margin. 0 sends
+	// every client the whole world.
+	InterestRadius float32
+	// InterestScale widens the interest radius by this many units per unit
+	// of the player's size (the radius its total mass would have as one cell).
+	InterestScale float32
+}
+
+// DefaultRules returns the rules used when nothing is configured.
+func DefaultRules() Rules {
+	return Rules{
+		Mode:  ModeFFA,
+		Teams: 2,
+
+		EatRatio:   1.15,
+		EatOverlap: 0.7,
+
+		Acceleration: 0.2,
+		Friction:     0.8,
+
+		MaxCells:       16,
+		SplitMinRadius: 2.0,
+		SplitSpeed:     4.0,
+		MergeTicks:     600,
+		MergePull:      0.05,
+
+		EjectMinRadius: 2.0,
+		EjectRadius:    0.2,
+		EjectSpeed:     4.0,
+		EjectDecay:     0.8,
+
+		DecayThreshold: 10,
+		DecayRate:      0.0002,
+
+		VirusCount:      10,
+		VirusMaxCount:   25,
+		VirusRadius:     3.5,
+		VirusFeedCount:  7,
+		VirusShootSpeed: 5,
+		VirusPopPieces:  8,
+		VirusMassLoss:   0.3,
+
+		RespawnCooldown:  60,
+		RespawnAutoTicks: 300,
+
+		SpawnCandidates:   16,
+		SpawnClearance:    10,
+		SpawnThreatRadius: 0,
+
+		FoodPerPlayer: 15,
+		FoodMax:       600,
+		FoodSpawnRate: 2,
+
+		FoodBackgroundWeight: 1,
+		FoodClusterSize:      1,
+		FoodClusterSpread:    3,
+
+		PowerUpCount: 4,
+		PowerUpTicks: 600,
+		SpeedBoost:   1.5,
+		MagnetRadius: 10,
+		MagnetPull:   0.6,
+
+		BotCount:      0,
+		BotDifficulty: 0.5,
+
+		RoundTicks:        0,
+		WarmupTicks:       300,
+		RoundEndTicks:     300,
+		IntermissionTicks: 600,
+
+		ZoneWaitTicks:    1800,
+		ZoneShrinkTicks:  600,
+		ZoneShrinkFactor: 0.7,
+		ZoneMinRadius:    10,
+		ZoneDamage:       0.02,
+
+		InterestRadius: 180,
+		InterestScale:  3,
+	}
+}
+
+// LoadRules reads a JSON rules file. Fields missing from the file keep their
+// DefaultRules value.
+func LoadRules(path string) (Rules, error) {
+	rules := DefaultRules()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return rules, err
+	}
+	err = json.Unmarshal(data, &rules)
+	return rules, err
+}
@@ -0,0 +1,46 @@
+package game
+
+import "math"
+
+// spawnPoint picks a position for a new cell of the given radius. It samples
+// SpawnCandidates random positions clear of the map geometry and takes the first whose edge is at
+// least SpawnClearance away from every threatening cell. When the arena is
+// too crowded for that, it falls back to the candidate with the most room.
+func (s *State) spawnPoint(radius float32) (x, y float32) {
+	best := float32(math.Inf(-1))
+	for i := 0; i < max(s.rules.SpawnCandidates, 1); i++ {
+		cx, cy := s.openPoint(radius)
+		room := s.clearance(cx, cy, radius)
+		if room > best {
+			x, y, best = cx, cy, room
+		}
+		if room >= s.rules.SpawnClearance {
+			break
+		}
+	}
+	return x, y
+}
+
+// clearance returns the gap between a cell of the given radius at (x, y)
+// and the nearest threatening cell, or +Inf when none is within
+// SpawnClearance. A cell threatens if it is at least SpawnThreatRadius, or
+// big enough to eat the new cell when SpawnThreatRadius is 0.
+func (s *State) clearance(x, y, radius float32) float32 {
+	threat := s.rules.SpawnThreatRadius
+	if threat <= 0 {
+		threat = radius * s.rules.EatRatio
+	}
+	room := float32(math.Inf(1))
+	s.cellHits = s.grid.queryCells(s.cellHits[:0], x, y, radius+s.rules.SpawnClearance)
+	for _, hit := range s.cellHits {
+		c := hit.c
+		if c.Radius < threat {
+			continue
+		}
+		dx, dy := float64(c.X-x), float64(c.Y-y)
+		if gap := float32(math.Hypot(dx, dy)) - c.Radius - radius; gap < room {
+			room = gap
+		}
+	}
+	return room
+}
@@ -1,54 +1,150 @@
 package game
 
 import (
+	"cmp"
+	"math"
 	"math/rand"
+	"slices"
+	"sort"
 	"sync"
 	"time"
 )
 
-// Input constants (aligned with gameframework demo)
+// Input constants (aligned with gameframework demo). These are the legacy
+// enum values; see input.go for the vector encoding.
 const (
-	InputNone  = 0
-	InputLeft  = 1
-	InputRight = 2
-	InputUp    = 3
-	InputDown  = 4
+	InputNone    = 0
+	InputLeft    = 1
+	InputRight   = 2
+	InputUp      = 3
+	InputDown    = 4
+	InputSplit   = 5 // split every cell in the last movement direction
+	InputEject   = 6 // eject a pellet of mass from every cell
+	InputRespawn = 7 // ask to respawn once dead and off cooldown
 )
 
+// startRadius is the radius of a freshly spawned player.
+const startRadius = 1.2
+
+// Player is one connected client. While alive it owns one or more cells;
+// while dead it owns none.
 type Player struct {
-	ID     uint16
+	ID    uint16
+	Cells []*Cell
+	// Team is the player's team in team mode, NoTeam otherwise.
+	Team uint8
+	// Status is alive or dead. KilledBy and DiedAt (a tick) describe the
+	// last death.
+	Status   PlayerStatus
+	KilledBy uint16
+	DiedAt   uint32
+	// RespawnIn is only set on snapshot copies: ticks left before a dead
+	// player may respawn.
+	RespawnIn uint32
+	// DirX/DirY is the unit direction of the last movement input; splits
+	// launch new cells along it.
+	DirX float32
+	DirY float32
+	// InputX/InputY is the current steering input (at most unit length).
+	// Cells accelerate towards it every tick until the next input arrives.
+	InputX float32
+	InputY float32
+	// Effects holds the ticks left on each power-up effect, indexed by
+	// PowerUpKind.
+	Effects [PowerUpKinds]uint32
+}
+
+// Mass returns the summed mass of all of p's cells.
+func (p *Player) Mass() float32 {
+	var m float32
+	for _, c := range p.Cells {
+		m += radiusToMass(c.Radius)
+	}
+	return m
+}
+
+// Cell is a single ball owned by a player.
+type Cell struct {
+	ID     uint32
 	X      float32
 	Y      float32
 	Radius float32
+	// VX/VY is the cell velocity in units per tick.
+	VX float32
+	VY float32
+	// MergeAt is the tick after which the cell may recombine with its siblings.
+	MergeAt uint32
 }
 
+// NoOwner marks food that was spawned by the world rather than ejected by a player.
+const NoOwner = math.MaxUint16
+
 type Food struct {
 	ID     uint32
 	X      float32
 	Y      float32
 	Value  float32
 	Radius float32
+	// VX/VY is the velocity of an ejected pellet; world food never moves.
+	VX float32
+	VY float32
+	// Owner is the player that ejected the pellet, or NoOwner.
+	Owner uint16
 }
 
-// State holds world state.
+// State holds world state. Everything that touches the RNG or resolves
+// conflicts walks players in ID order (order) and flying pellets in launch
+// order (flying), never map order, so a given seed and input log always
+// produce the same world.
 type State struct {
-	mu        sync.Mutex
-	Players   map[uint16]*Player
-	Foods     map[uint32]*Food
-	arenaHalf float32
-	rng       *rand.Rand
+	mu          sync.Mutex
+	Players     map[uint16]*Player
+	Foods       map[uint32]*Food
+	Viruses     []*Virus   // sorted by ID
+	PowerUps    []*PowerUp // sorted by ID
+	order       []*Player  // Players sorted by ID
+	flying      []*Food    // ejected pellets that are still moving
+	arenaHalf   float32
+	arena       *ArenaMap // static obstacles, nil for an empty arena
+	rules       Rules
+	rng         *rand.Rand
+	tick        uint32
+	nextCell    uint32
+	nextFood    uint32
+	nextVirus   uint32
+	nextPowerUp uint32
+	grid        *spatialGrid
+	foodCount   int  // base number of world pellets, see foodTarget
+	worldFood   int  // world (not ejected) pellets currently in Foods
+	peaceful    bool // players cannot eat each other (see SetPeaceful)
+	zone        zone // battle-royale safe zone
+
+	// centre and remaining pellets of the food cluster being spawned
+	clusterX, clusterY float32
+	clusterLeft        int
+
+	// scratch buffers for grid queries, reused across ticks
+	foodHits []*Food
+	cellHits []cellRef
 }
 
-func NewState(arenaHalf float32, foodCount int) *State {
+// NewState creates a world with foodCount pellets. A zero seed picks one from
+// the clock.
+func NewState(arenaHalf float32, foodCount int, rules Rules, seed int64, arena *ArenaMap) *State {
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
 	s := &State{
 		Players:   make(map[uint16]*Player),
 		Foods:     make(map[uint32]*Food),
 		arenaHalf: arenaHalf,
-		rng:       rand.New(rand.NewSource(time.Now().UnixNano())),
-	}
-	for i := 0; i < foodCount; i++ {
-		s.spawnFood()
+		arena:     arena,
+		rules:     rules,
+		rng:       rand.New(rand.NewSource(seed)),
+		grid:      newSpatialGrid(gridCellSize, arenaHalf),
+		foodCount: foodCount,
 	}
+	s.populate()
 	return s
 }
 
@@ -56,19 +152,41 @@ func NewState(arenaHalf float32, foodCount int) *State {
 func (s *State) AddPlayer(id uint16) *Player {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	p := &Player{
-		ID:     id,
-		X:      s.randInRange(),
-		Y:      s.randInRange(),
-		Radius: 1.2,
+	p := &Player{ID: id, DirX: 1, Team: s.pickTeam()}
+	if s.zone.active {
+		// a battle-royale round is under way: spectate until the next one
+		s.kill(p, NoOwner)
+	} else {
+		s.respawn(p)
 	}
-	s.Players[id] = p
+	s.insertPlayer(p)
 	return p
 }
 
+// insertPlayer adds p to the player map and the ID-sorted order, replacing
+// any player with the same ID.
+func (s *State) insertPlayer(p *Player) {
+	i := sort.Search(len(s.order), func(i int) bool { return s.order[i].ID >= p.ID })
+	if i < len(s.order) && s.order[i].ID == p.ID {
+		s.order[i] = p
+	} else {
+		s.order = slices.Insert(s.order, i, p)
+	}
+	s.Players[p.ID] = p
+}
+
+func (s *State) newCell(x, y, radius float32) *Cell {
+	s.nextCell++
+	return &Cell{ID: s.nextCell, X: x, Y: y, Radius: radius}
+}
+
 func (s *State) RemovePlayer(id uint16) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	i := sort.Search(len(s.order), func(i int) bool { return s.order[i].ID >= id })
+	if i < len(s.order) && s.order[i].ID == id {
+		s.order = slices.Delete(s.order, i, i+1)
+	}
 	delete(s.Players, id)
 }
 
@@ -76,78 +194,323 @@ func (s *State) randInRange() float32 {
 	return (s.rng.Float32()*2 - 1) * s.arenaHalf
 }
 
-// ApplyInput moves player and checks food eats.
+// ApplyInput records the player's steering input and performs its one-shot
+// actions. Movement itself happens in Step. Players join through AddPlayer;
+// input for unknown players is ignored, and dead players can only ask to
+// respawn.
 func (s *State) ApplyInput(pid uint16, input uint32) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	p, ok := s.Players[pid]
 	if !ok {
-		// late join safety
-		p = &Player{ID: pid, X: s.randInRange(), Y: s.randInRange(), Radius: 1.2}
-		s.Players[pid] = p
+		return
 	}
 
-	speedFactor := 1.5 / (1.0 + float32(p.Radius))
+	dx, dy, actions := DecodeInput(input)
+	if p.Status == StatusDead {
+		if actions&InputActionRespawn != 0 && s.canRespawn(p) {
+			s.respawn(p)
+		}
+		return
+	}
+	p.InputX, p.InputY = dx, dy
+	if mag := float32(math.Hypot(float64(dx), float64(dy))); mag > 0 {
+		p.DirX, p.DirY = dx/mag, dy/mag
+	}
+	if actions&InputActionSplit != 0 {
+		s.split(p)
+	}
+	if actions&InputActionEject != 0 {
+		s.eject(p)
+	}
+}
+
+// moveCells accelerates every cell towards its player's steering input,
+// applies friction and integrates the position. The input is at most unit
+// length, so diagonals are no faster than straight moves.
+func (s *State) moveCells() {
+	for _, p := range s.order {
+		cx, cy := centreOfMass(p)
+		for _, c := range p.Cells {
+			top := topSpeed(c.Radius, s.speedBoost(p))
+			accel := s.rules.Acceleration * top
+			c.VX = c.VX*s.rules.Friction + p.InputX*accel
+			c.VY = c.VY*s.rules.Friction + p.InputY*accel
+			// cells free to merge drift towards their siblings
+			if len(p.Cells) > 1 && s.tick >= c.MergeAt {
+				dx, dy := cx-c.X, cy-c.Y
+				if d := float32(math.Sqrt(float64(dx*dx + dy*dy))); d > 0.01 {
+					pull := s.rules.MergePull * top / d
+					c.VX += dx * pull
+					c.VY += dy * pull
+				}
+			}
+			if c.VX*c.VX+c.VY*c.VY < 0.0001 {
+				c.VX, c.VY = 0, 0
+			}
+			// keep inside the arena and slide along obstacles
+			c.X, c.Y, c.VX, c.VY = s.bounce(c.X+c.VX, c.Y+c.VY, c.VX, c.VY, c.Radius)
+		}
+	}
+}
+
+// centreOfMass returns the mass-weighted centre of p's cells.
+func centreOfMass(p *Player) (x, y float32) {
+	var total float32
+	for _, c := range p.Cells {
+		m := radiusToMass(c.Radius)
+		x += c.X * m
+		y += c.Y * m
+		total += m
+	}
+	if total == 0 {
+		return 0, 0
+	}
+	return x / total, y / total
+}
+
+// topSpeed is the speed a cell of the given radius settles at when steering
+// at full input with Acceleration == 1-Friction. Bigger is slower; boost is
+// the speed power-up multiplier (1 without it).
+func topSpeed(radius, boost float32) float32 {
+	speedFactor := 1.5 / (1.0 + radius)
 	if speedFactor < 0.4 {
 		speedFactor = 0.4
 	}
-	speed := float32(2.0) * speedFactor // bigger slower (increased from 0.8 to 2.0 for faster movement)
-	switch input {
-	case InputLeft:
-		p.X -= speed
-	case InputRight:
-		p.X += speed
-	case InputUp:
-		p.Y += speed
-	case InputDown:
-		p.Y -= speed
-	}
-	// clamp to arena
-	p.X = clamp(p.X, -s.arenaHalf, s.arenaHalf)
-	p.Y = clamp(p.Y, -s.arenaHalf, s.arenaHalf)
-
-	// eat foods
-	for id, f := range s.Foods {
-		if collide(p.X, p.Y, p.Radius, f.X, f.Y, f.Radius) {
-			p.Radius += f.Value
-			delete(s.Foods, id)
-			s.spawnFood()
+	speedFactor *= boost
+	return 2.0 * speedFactor
+}
+
+// eatFoods lets every cell eat the food it touches.
+func (s *State) eatFoods() {
+	for _, p := range s.order {
+		for _, c := range p.Cells {
+			s.foodHits = s.grid.queryFoods(s.foodHits[:0], c.X, c.Y, c.Radius)
+			for _, f := range s.foodHits {
+				if s.canEatFood(p.ID, c, f) {
+					s.eatFood(c, f)
+				}
+			}
+		}
+	}
+}
+
+// canEatFood reports whether cell c of player pid touches f and may eat it.
+// Players cannot catch their own pellets while they are still flying.
+func (s *State) canEatFood(pid uint16, c *Cell, f *Food) bool {
+	if f.Owner == pid && (f.VX != 0 || f.VY != 0) {
+		return false
+	}
+	return collide(c.X, c.Y, c.Radius, f.X, f.Y, f.Radius)
+}
+
+// eatFood grows c by f's value and removes f. World food is replaced so the
+// pellet count stays constant; ejected pellets are not.
+func (s *State) eatFood(c *Cell, f *Food) {
+	c.Radius += f.Value
+	s.grid.cellGrew(c)
+	s.removeFood(f)
+}
+
+// Step advances every player and pellet by one tick: power-up timers, mass
+// decay, movement, magnets, food eating, ejected pellets, viruses, sibling
+// merging, power-up pickups, player-vs-player eating, the battle-royale zone
+// automatic respawns and food refills.
+func (s *State) Step(tick uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tick = tick
+	s.tickEffects()
+	s.decayCells()
+	s.moveCells()
+	s.grid.indexCells(s.order)
+	s.pullFood()
+	s.eatFoods()
+	s.moveEjectedFood()
+	s.moveViruses()
+	s.hitViruses()
+	s.mergeCells()
+	s.grid.indexCells(s.order)
+	s.pickUpPowerUps()
+	s.resolveEats()
+	s.burnOutsideZone()
+	s.autoRespawn()
+	s.refillFood()
+}
+
+// populate fills an empty arena with its initial food and viruses.
+func (s *State) populate() {
+	for i := s.foodTarget(); i > 0; i-- {
+		s.spawnFood()
+	}
+	for i := 0; i < s.rules.VirusCount; i++ {
+		s.spawnVirus()
+	}
+	for i := 0; i < s.rules.PowerUpCount; i++ {
+		s.spawnPowerUp()
+	}
+}
+
+// resolveEats lets every cell absorb the smaller cells of other players it
+// sufficiently covers. Shielded players cannot be eaten. A player that
+// loses its last cell dies; the eater of that cell is recorded as the killer.
+func (s *State) resolveEats() {
+	if s.peaceful {
+		return
+	}
+	eaten := make(map[*Cell]uint16) // victim cell -> eater player ID
+	for _, p := range s.order {
+		for _, c := range p.Cells {
+			if _, gone := eaten[c]; gone {
+				continue
+			}
+			// a victim is always smaller than c, so its centre lies within 2*c.Radius
+			s.cellHits = s.grid.queryCells(s.cellHits[:0], c.X, c.Y, 2*c.Radius)
+			for _, hit := range s.cellHits {
+				if _, gone := eaten[hit.c]; gone || hit.p == p || teammates(hit.p, p) || hit.p.has(PowerShield) || !s.canEat(c, hit.c) {
+					continue
+				}
+				c.Radius = massToRadius(radiusToMass(c.Radius) + radiusToMass(hit.c.Radius))
+				s.grid.cellGrew(c)
+				eaten[hit.c] = p.ID
+			}
 		}
 	}
+	if len(eaten) == 0 {
+		return
+	}
+	for _, p := range s.order {
+		if len(p.Cells) == 0 {
+			continue
+		}
+		var killer uint16
+		kept := p.Cells[:0]
+		for _, c := range p.Cells {
+			if eater, gone := eaten[c]; gone {
+				killer = eater
+				continue
+			}
+			kept = append(kept, c)
+		}
+		p.Cells = kept
+		if len(p.Cells) == 0 {
+			s.kill(p, killer)
+		}
+	}
+}
+
+// canEat reports whether cell a is big enough and close enough to eat cell b.
+func (s *State) canEat(a, b *Cell) bool {
+	return s.covers(a.X, a.Y, a.Radius, b.X, b.Y, b.Radius)
 }
 
-func (s *State) spawnFood() {
-	id := uint32(len(s.Foods) + 1 + int(s.rng.Int31()))
-	s.Foods[id] = &Food{
-		ID:     id,
-		X:      s.randInRange(),
-		Y:      s.randInRange(),
-		Value:  0.15,
-		Radius: 0.35,
+// covers reports whether circle a is EatRatio times larger than circle b
+// and covers EatOverlap of b's diameter.
+func (s *State) covers(ax, ay, ar, bx, by, br float32) bool {
+	if ar < br*s.rules.EatRatio {
+		return false
 	}
+	reach := float64(ar + br - 2*br*s.rules.EatOverlap)
+	if reach < 0 {
+		return false
+	}
+	dx := float64(ax - bx)
+	dy := float64(ay - by)
+	return dx*dx+dy*dy <= reach*reach
+}
+
+// addFood registers f in the food map and the spatial grid.
+func (s *State) addFood(f *Food) {
+	if f.Owner == NoOwner {
+		s.worldFood++
+	}
+	s.Foods[f.ID] = f
+	s.grid.insertFood(f)
+}
+
+// removeFood is the inverse of addFood.
+func (s *State) removeFood(f *Food) {
+	if f.Owner == NoOwner {
+		s.worldFood--
+	}
+	delete(s.Foods, f.ID)
+	s.grid.removeFood(f, f.X, f.Y)
+}
+
+// newFoodID hands out food IDs in increasing order, skipping 0. Once the
+// counter wraps around it also skips IDs that are still in use, so an ID is
+// never shared by two live pellets and is only reused after the whole 32-bit
+// space has been cycled through.
+func (s *State) newFoodID() uint32 {
+	for {
+		s.nextFood++
+		if s.nextFood == 0 {
+			continue
+		}
+		if _, used := s.Foods[s.nextFood]; !used {
+			return s.nextFood
+		}
+	}
+}
+
+// WorldFoodCount returns how many world-spawned (not ejected) pellets exist.
+func (s *State) WorldFoodCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.worldFood
 }
 
 // Snapshot returns copies for broadcast.
 type Snapshot struct {
-	Players []*Player
-	Foods   []*Food
+	Players  []*Player
+	Foods    []*Food
+	Viruses  []*Virus
+	PowerUps []*PowerUp
+	// ZoneRadius is the battle-royale safe radius (0 when there is no zone)
+	// and ZoneShrinkIn the ticks until it next starts shrinking.
+	ZoneRadius   float32
+	ZoneShrinkIn uint32
+	// TeamMasses is the summed mass of every team (see TeamMasses).
+	TeamMasses []float32
+	// ArenaHalf is the arena half-size, which snapshots quantize positions against.
+	ArenaHalf float32
 }
 
 func (s *State) Snapshot() Snapshot {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	out := Snapshot{
-		Players: make([]*Player, 0, len(s.Players)),
-		Foods:   make([]*Food, 0, len(s.Foods)),
+		Players:  make([]*Player, 0, len(s.Players)),
+		Foods:    make([]*Food, 0, len(s.Foods)),
+		Viruses:  make([]*Virus, 0, len(s.Viruses)),
+		PowerUps: make([]*PowerUp, 0, len(s.PowerUps)),
 	}
-	for _, p := range s.Players {
+	out.ZoneRadius, out.ZoneShrinkIn = s.zoneAt(s.tick)
+	out.TeamMasses = s.teamMasses()
+	out.ArenaHalf = s.arenaHalf
+	for _, p := range s.order {
 		cp := *p
+		cp.RespawnIn = s.respawnIn(p)
+		cp.Cells = make([]*Cell, len(p.Cells))
+		for i, c := range p.Cells {
+			cc := *c
+			cp.Cells[i] = &cc
+		}
 		out.Players = append(out.Players, &cp)
 	}
 	for _, f := range s.Foods {
 		cf := *f
 		out.Foods = append(out.Foods, &cf)
 	}
+	slices.SortFunc(out.Foods, func(a, b *Food) int { return cmp.Compare(a.ID, b.ID) })
+	for _, v := range s.Viruses {
+		cv := *v
+		out.Viruses = append(out.Viruses, &cv)
+	}
+	for _, pu := range s.PowerUps {
+		cpu := *pu
+		out.PowerUps = append(out.PowerUps, &cpu)
+	}
 	return out
 }
 
@@ -161,6 +524,16 @@ func clamp(v, min, max float32) float32 {
 	return v
 }
 
+// radiusToMass treats mass as the ball's area (without the pi factor) so that
+// merging two balls conserves their combined area.
+func radiusToMass(r float32) float32 {
+	return r * r
+}
+
+func massToRadius(m float32) float32 {
+	return float32(math.Sqrt(float64(m)))
+}
+
 func collide(x1, y1, r1, x2, y2, r2 float32) bool {
 	dx := float64(x1 - x2)
 	dy := float64(y1 - y2)
@@ -0,0 +1,127 @@
+package game
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// runFood plays players for ticks ticks with random steering, splits and
+// ejections, checking the food invariants after every tick. everSeen
+// collects every food ID that was live at some point; reuse says
+// whether an ID may legitimately come back (only after a wraparound).
+func runFood(t *testing.T, s *State, players, ticks int, everSeen map[uint32]bool, reuse bool) {
+	t.Helper()
+	rng := rand.New(rand.NewSource(7))
+	live := make(map[uint32]bool)
+	for id := range s.Foods {
+		live[id] = true
+		everSeen[id] = true
+	}
+	for tick := 1; tick <= ticks; tick++ {
+		for i := 1; i <= players; i++ {
+			a := rng.Float64() * 2 * math.Pi
+			var actions uint32
+			switch rng.Intn(10) {
+			case 0:
+				actions = InputActionEject
+			case 1:
+				actions = InputActionSplit
+			}
+			s.ApplyInput(uint16(i), EncodeInput(float32(math.Cos(a)), float32(math.Sin(a)), actions|InputActionRespawn))
+		}
+		s.Step(uint32(tick))
+		checkFood(t, s, tick)
+
+		for id := range s.Foods {
+			if !live[id] && everSeen[id] && !reuse {
+				t.Fatalf("tick %d: food ID %d reused", tick, id)
+			}
+			everSeen[id] = true
+		}
+		clear(live)
+		for id := range s.Foods {
+			live[id] = true
+		}
+	}
+}
+
+// checkFood asserts the world holds exactly its food target and that no
+// two live pellets share an ID.
+func checkFood(t *testing.T, s *State, tick int) {
+	t.Helper()
+	if got, want := s.WorldFoodCount(), s.FoodTarget(); got != want {
+		t.Fatalf("tick %d: %d world pellets, target %d", tick, got, want)
+	}
+	world := 0
+	for id, f := range s.Foods {
+		if id == 0 || f.ID != id {
+			t.Fatalf("tick %d: pellet %d stored under ID %d", tick, f.ID, id)
+		}
+		if f.Owner == NoOwner {
+			world++
+		}
+	}
+	// an overwritten map entry would leave the counter ahead of the map
+	if world != s.worldFood {
+		t.Fatalf("tick %d: %d world pellets in the map, counter says %d", tick, world, s.worldFood)
+	}
+	// every pellet is indexed once; two pellets sharing an ID would both
+	// still be in the grid
+	seen := make(map[uint32]*Food, len(s.Foods))
+	for _, bucket := range s.grid.foods {
+		for _, f := range bucket {
+			if other, dup := seen[f.ID]; dup {
+				t.Fatalf("tick %d: ID %d shared by %p and %p", tick, f.ID, f, other)
+			}
+			seen[f.ID] = f
+		}
+	}
+	if len(seen) != len(s.Foods) {
+		t.Fatalf("tick %d: %d pellets indexed, %d in the map", tick, len(seen), len(s.Foods))
+	}
+}
+
+func foodTestState(t *testing.T, players int) *State {
+	t.Helper()
+	rules := DefaultRules()
+	rules.RoundTicks = 0
+	// a fixed target refilled instantly, so the world always holds exactly it
+	rules.FoodPerPlayer, rules.FoodMax, rules.FoodSpawnRate = 0, 0, 0
+	s := NewState(100, 500, rules, 1, nil)
+	for i := 1; i <= players; i++ {
+		s.AddPlayer(uint16(i)).Cells[0].Radius = 5 // big enough to split and eject
+	}
+	checkFood(t, s, 0)
+	return s
+}
+
+func TestFoodCountConserved(t *testing.T) {
+	s := foodTestState(t, 12)
+	runFood(t, s, 12, 5000, make(map[uint32]bool), false)
+}
+
+func TestFoodIDWraparound(t *testing.T) {
+	s := foodTestState(t, 12)
+	s.nextFood = math.MaxUint32 - 100
+	runFood(t, s, 12, 2000, make(map[uint32]bool), true)
+	if s.nextFood > math.MaxUint32-100 {
+		t.Fatalf("allocator never wrapped: next ID %d", s.nextFood)
+	}
+}
+
+func TestNewFoodIDSkipsLiveIDs(t *testing.T) {
+	s := NewState(100, 0, DefaultRules(), 1, nil)
+	for _, id := range []uint32{math.MaxUint32, 1, 2, 4} {
+		s.addFood(&Food{ID: id, Owner: NoOwner})
+	}
+	s.nextFood = math.MaxUint32 - 1
+	var got []uint32
+	for range 3 {
+		got = append(got, s.newFoodID())
+	}
+	// MaxUint32 and 1, 2, 4 are taken and 0 is never handed out
+	if want := []uint32{3, 5, 6}; got[0] != want[0] || got[1] != want[1] || got[2] != want[2] {
+		t.Fatalf("IDs after wraparound: got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,66 @@
+package game
+
+import "slices"
+
+// Mode selects the game mode.
+type Mode string
+
+const (
+	// ModeFFA is every player for themselves.
+	ModeFFA Mode = "ffa"
+	// ModeTeams splits players into Rules.Teams balanced teams. Teammates
+	// cannot eat each other and a team scores its summed mass.
+	ModeTeams Mode = "teams"
+	// ModeRoyale is a battle royale: the safe zone shrinks during each
+	// round, nobody respawns and the last survivor wins. It needs rounds.
+	ModeRoyale Mode = "royale"
+)
+
+// Valid reports whether m is a known mode.
+func (m Mode) Valid() bool {
+	return m == ModeFFA || m == ModeTeams || m == ModeRoyale
+}
+
+// NoTeam is the Team of every player outside team mode.
+const NoTeam uint8 = 0
+
+// pickTeam returns the team with the fewest members, preferring the lower
+// number on a tie. Teams are numbered from 1.
+func (s *State) pickTeam() uint8 {
+	if s.rules.Mode != ModeTeams || s.rules.Teams < 1 {
+		return NoTeam
+	}
+	sizes := make([]int, s.rules.Teams)
+	for _, p := range s.order {
+		if p.Team != NoTeam && int(p.Team) <= len(sizes) {
+			sizes[p.Team-1]++
+		}
+	}
+	return uint8(slices.Index(sizes, slices.Min(sizes)) + 1)
+}
+
+// teammates reports whether a and b play for the same team.
+func teammates(a, b *Player) bool {
+	return a.Team != NoTeam && a.Team == b.Team
+}
+
+// TeamMasses returns the summed mass of every team, indexed by team number
+// minus one. It is empty outside team mode.
+func (s *State) TeamMasses() []float32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.teamMasses()
+}
+
+func (s *State) teamMasses() []float32 {
+	if s.rules.Mode != ModeTeams {
+		return nil
+	}
+	masses := make([]float32, s.rules.Teams)
+	for _, p := range s.order {
+		if p.Team != NoTeam && int(p.Team) <= len(masses) {
+			masses[p.Team-1] += p.Mass()
+		}
+	}
+	return masses
+}
@@ -0,0 +1,142 @@
+package game
+
+import "math"
+
+// Virus is a spiky hazard. Cells too small to cover it can hide behind it;
+// cells big enough to cover it absorb it and burst into pieces. Feeding a
+// virus VirusFeedCount ejected pellets makes it shoot out a new virus in the
+// direction the last pellet was travelling.
+type Virus struct {
+	ID     uint32
+	X      float32
+	Y      float32
+	Radius float32
+	// VX/VY is the velocity of a freshly shot virus; it slows down like an
+	// ejected pellet.
+	VX float32
+	VY float32
+	// Fed counts pellets absorbed since the virus last shot.
+	Fed int
+}
+
+func (s *State) spawnVirus() {
+	s.addVirus(s.openPoint(s.rules.VirusRadius))
+}
+
+func (s *State) addVirus(x, y float32) *Virus {
+	s.nextVirus++
+	v := &Virus{ID: s.nextVirus, X: x, Y: y, Radius: s.rules.VirusRadius}
+	s.Viruses = append(s.Viruses, v)
+	return v
+}
+
+// moveViruses slides shot viruses until they come to rest.
+func (s *State) moveViruses() {
+	for _, v := range s.Viruses {
+		if v.VX == 0 && v.VY == 0 {
+			continue
+		}
+		v.X, v.Y, v.VX, v.VY = s.bounce(v.X+v.VX, v.Y+v.VY, v.VX, v.VY, v.Radius)
+		v.VX *= s.rules.EjectDecay
+		v.VY *= s.rules.EjectDecay
+		if v.VX*v.VX+v.VY*v.VY < 0.0001 {
+			v.VX, v.VY = 0, 0
+		}
+	}
+}
+
+// feedVirus lets the first virus that a flying pellet hits swallow it and
+// reports whether that happened.
+func (s *State) feedVirus(f *Food) bool {
+	for _, v := range s.Viruses {
+		if !collide(v.X, v.Y, v.Radius, f.X, f.Y, f.Radius) {
+			continue
+		}
+		s.removeFood(f)
+		v.Fed++
+		if v.Fed >= s.rules.VirusFeedCount {
+			v.Fed = 0
+			s.shootVirus(v, f.VX, f.VY)
+		}
+		return true
+	}
+	return false
+}
+
+// shootVirus launches a new virus from v along (dx, dy).
+func (s *State) shootVirus(v *Virus, dx, dy float32) {
+	if len(s.Viruses) >= s.rules.VirusMaxCount {
+		return
+	}
+	d := float32(math.Hypot(float64(dx), float64(dy)))
+	if d == 0 {
+		return
+	}
+	dx, dy = dx/d, dy/d
+	nv := s.addVirus(
+		clamp(v.X+dx*2*v.Radius, -s.arenaHalf, s.arenaHalf),
+		clamp(v.Y+dy*2*v.Radius, -s.arenaHalf, s.arenaHalf),
+	)
+	nv.VX = dx * s.rules.VirusShootSpeed
+	nv.VY = dy * s.rules.VirusShootSpeed
+}
+
+// hitViruses pops every cell that is big enough to cover a virus. The
+// virus is consumed and, if the world is below VirusCount, replaced.
+func (s *State) hitViruses() {
+	kept := s.Viruses[:0]
+	for _, v := range s.Viruses {
+		if !s.popFirstCover(v) {
+			kept = append(kept, v)
+		}
+	}
+	clear(s.Viruses[len(kept):])
+	s.Viruses = kept
+	for len(s.Viruses) < s.rules.VirusCount {
+		s.spawnVirus()
+	}
+}
+
+// popFirstCover bursts the first indexed cell that covers v and reports
+// whether one did.
+func (s *State) popFirstCover(v *Virus) bool {
+	s.cellHits = s.grid.queryCells(s.cellHits[:0], v.X, v.Y, v.Radius)
+	for _, hit := range s.cellHits {
+		c := hit.c
+		if !s.covers(c.X, c.Y, c.Radius, v.X, v.Y, v.Radius) {
+			continue
+		}
+		c.Radius = massToRadius(radiusToMass(c.Radius) + radiusToMass(v.Radius))
+		s.popCell(hit.p, c)
+		return true
+	}
+	return false
+}
+
+// popCell bursts c into up to VirusPopPieces equal pieces flying outwards.
+// If p has no room for more cells (or splitting is disabled with
+// MaxCells <= 1) c loses VirusMassLoss of its mass instead.
+func (s *State) popCell(p *Player, c *Cell) {
+	mass := radiusToMass(c.Radius)
+	pieces := min(s.rules.VirusPopPieces, s.rules.MaxCells-len(p.Cells)+1)
+	if pieces <= 1 {
+		c.Radius = massToRadius(mass * (1 - s.rules.VirusMassLoss))
+		return
+	}
+	r := massToRadius(mass / float32(pieces))
+	c.Radius = r
+	c.MergeAt = s.tick + s.rules.MergeTicks
+	for i := 1; i < pieces; i++ {
+		a := 2 * math.Pi * float64(i) / float64(pieces-1)
+		dx, dy := float32(math.Cos(a)), float32(math.Sin(a))
+		nc := s.newCell(
+			clamp(c.X+dx*r, -s.arenaHalf, s.arenaHalf),
+			clamp(c.Y+dy*r, -s.arenaHalf, s.arenaHalf),
+			r,
+		)
+		nc.VX = c.VX + dx*s.rules.SplitSpeed
+		nc.VY = c.VY + dy*s.rules.SplitSpeed
+		nc.MergeAt = c.MergeAt
+		p.Cells = append(p.Cells, nc)
+	}
+}
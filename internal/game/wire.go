@@ -0,0 +1,97 @@
+package game
+
+import (
+	"cmp"
+	"math"
+	"slices"
+
+	"ballbattle/internal/snapshot"
+)
+
+// Player records carry one timer per power-up kind.
+var _ [snapshot.EffectKinds]uint16 = [PowerUpKinds]uint16{}
+
+// World converts snap and the round clock into the wire form written by
+// the snapshot package. Cells of all players are listed together, sorted
+// by ID; timers longer than the wire allows are clamped.
+func (snap Snapshot) World(round RoundInfo) *snapshot.World {
+	w := &snapshot.World{
+		ArenaHalf: snap.ArenaHalf,
+		Round: snapshot.Round{
+			Phase:      uint8(round.Phase),
+			Number:     round.Round,
+			Remaining:  round.Remaining,
+			Winner:     round.Winner,
+			WinnerTeam: round.WinnerTeam,
+			WinnerMass: round.WinnerMass,
+		},
+		ZoneRadius:   snap.ZoneRadius,
+		ZoneShrinkIn: snap.ZoneShrinkIn,
+		TeamMasses:   snap.TeamMasses,
+		Players:      make([]snapshot.Player, 0, len(snap.Players)),
+		Foods:        make([]snapshot.Food, 0, len(snap.Foods)),
+		Viruses:      make([]snapshot.Virus, 0, len(snap.Viruses)),
+		PowerUps:     make([]snapshot.PowerUp, 0, len(snap.PowerUps)),
+	}
+	for _, p := range snap.Players {
+		sp := snapshot.Player{
+			ID:        p.ID,
+			Team:      p.Team,
+			Status:    uint8(p.Status),
+			KilledBy:  p.KilledBy,
+			DiedAt:    p.DiedAt,
+			RespawnIn: uint16(min(p.RespawnIn, math.MaxUint16)),
+		}
+		for k, left := range p.Effects {
+			sp.Effects[k] = uint16(min(left, math.MaxUint16))
+		}
+		w.Players = append(w.Players, sp)
+		for _, c := range p.Cells {
+			w.Cells = append(w.Cells, snapshot.Cell{Owner: p.ID, ID: c.ID, X: c.X, Y: c.Y, Radius: c.Radius})
+		}
+	}
+	slices.SortFunc(w.Cells, func(a, b snapshot.Cell) int { return cmp.Compare(a.ID, b.ID) })
+
+	// foods, viruses and power-ups already come sorted by ID
+	for _, f := range snap.Foods {
+		w.Foods = append(w.Foods, snapshot.Food{ID: f.ID, X: f.X, Y: f.Y, Value: f.Value, Radius: f.Radius, Owner: f.Owner})
+	}
+	for _, v := range snap.Viruses {
+		w.Viruses = append(w.Viruses, snapshot.Virus{ID: v.ID, X: v.X, Y: v.Y, Radius: v.Radius})
+	}
+	for _, pu := range snap.PowerUps {
+		w.PowerUps = append(w.PowerUps, snapshot.PowerUp{ID: pu.ID, Kind: uint8(pu.Kind), X: pu.X, Y: pu.Y})
+	}
+	return w
+}
+
+// frame is the snapshot of one tick, encoded once and shared by every
+// client that receives it.
+type frame struct {
+	*snapshot.Frame
+	focus map[uint16]focus // where each live player is looking
+}
+
+func encodeFrame(tick uint32, snap Snapshot, round RoundInfo) *frame {
+	f := &frame{Frame: snapshot.NewFrame(tick, snap.World(round)), focus: make(map[uint16]focus)}
+	for _, p := range snap.Players {
+		if len(p.Cells) > 0 {
+			f.focus[p.ID] = focusOf(p)
+		}
+	}
+	return f
+}
+
+// clientView is what the server remembers about one client: the
+// snapshots sent to it and where it was last looking.
+type clientView struct {
+	sent *snapshot.History
+
+	// last place the player looked while alive; dead players keep watching it
+	focus    focus
+	hasFocus bool
+}
+
+func newClientView() *clientView {
+	return &clientView{sent: snapshot.NewHistory()}
+}
@@ -0,0 +1,99 @@
+package game
+
+import "math"
+
+// zoneDeathRadius is the radius below which a cell starved by the zone is
+// removed outright.
+const zoneDeathRadius = 0.5
+
+// zone is the battle-royale safe circle, centred on the origin. While active
+// it shrinks in stages: each stage waits ZoneWaitTicks, then shrinks the
+// radius by ZoneShrinkFactor over ZoneShrinkTicks, until ZoneMinRadius.
+type zone struct {
+	active bool
+	start  uint32 // tick the zone was activated
+}
+
+// StartZone activates the safe zone at tick. Respawning is disabled until
+// StopZone, so the round ends with the last survivor.
+func (s *State) StartZone(tick uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.zone = zone{active: true, start: tick}
+}
+
+// StopZone deactivates the safe zone.
+func (s *State) StopZone() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.zone = zone{}
+}
+
+// zoneAt returns the safe radius at tick and how many ticks remain before
+// the next shrink starts (0 while shrinking or once fully shrunk). The
+// radius is 0 while the zone is inactive.
+func (s *State) zoneAt(tick uint32) (radius float32, shrinkIn uint32) {
+	if !s.zone.active {
+		return 0, 0
+	}
+	r := s.rules
+	full := float64(s.arenaHalf) * math.Sqrt2 // covers the corners of the arena
+	stageLen := r.ZoneWaitTicks + r.ZoneShrinkTicks
+	if stageLen == 0 {
+		return float32(full), 0
+	}
+	elapsed := tick - s.zone.start
+	stage, into := elapsed/stageLen, elapsed%stageLen
+	from := math.Max(full*math.Pow(float64(r.ZoneShrinkFactor), float64(stage)), float64(r.ZoneMinRadius))
+	if from <= float64(r.ZoneMinRadius) {
+		return r.ZoneMinRadius, 0
+	}
+	if into < r.ZoneWaitTicks {
+		return float32(from), r.ZoneWaitTicks - into
+	}
+	to := math.Max(from*float64(r.ZoneShrinkFactor), float64(r.ZoneMinRadius))
+	t := float64(into-r.ZoneWaitTicks) / float64(max(r.ZoneShrinkTicks, 1))
+	return float32(from + (to-from)*t), 0
+}
+
+// burnOutsideZone drains ZoneDamage of the mass of every cell whose centre
+// lies outside the safe zone. Players that lose their last cell this way
+// die with NoOwner as the killer.
+func (s *State) burnOutsideZone() {
+	radius, _ := s.zoneAt(s.tick)
+	if radius == 0 {
+		return
+	}
+	for _, p := range s.order {
+		if len(p.Cells) == 0 {
+			continue
+		}
+		kept := p.Cells[:0]
+		for _, c := range p.Cells {
+			if float32(math.Hypot(float64(c.X), float64(c.Y))) > radius {
+				c.Radius = massToRadius(radiusToMass(c.Radius) * (1 - s.rules.ZoneDamage))
+			}
+			if c.Radius >= zoneDeathRadius {
+				kept = append(kept, c)
+			}
+		}
+		p.Cells = kept
+		if len(p.Cells) == 0 {
+			s.kill(p, NoOwner)
+		}
+	}
+}
+
+// LastStanding reports whether at most one of two or more players is still
+// alive.
+func (s *State) LastStanding() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	alive := 0
+	for _, p := range s.order {
+		if p.Status == StatusAlive {
+			alive++
+		}
+	}
+	return len(s.order) >= 2 && alive <= 1
+}
@@ -1,30 +1,96 @@
 package server
 
 import (
+	"fmt"
+
+	"ballbattle/internal/channel"
 	"ballbattle/internal/game"
 	"gameframework/pkg/netcore"
 )
 
 // Server 封装 netcore.Server，简化接口
 type Server struct {
-	netcore *netcore.Server
+	netcore   *netcore.Server
+	snapshots *snapshotChannel // 为 nil 时快照随框架的帧广播
 }
 
-// New 创建服务器，使用 netcore 封装
-func New(listen string, tickHz int, foodCount int, arenaHalf float32) (*Server, error) {
-	// 创建游戏状态
-	state := game.NewState(arenaHalf, foodCount)
-	
+// 关闭快照通道时快照随框架的帧广播：[Tick: uint32] [输入数: uint8] [PlayerID: uint16, Input: uint32]... [快照长度: uint16] [快照]。
+// 输入数只有 8 位，超过 255 个玩家时帧无法解析；整帧（加上 8 字节 UDP 头部）必须放进一个 UDP 数据报
+const (
+	maxFramePlayers  = 255
+	maxFrameSnapshot = 65507 - 8 - 4 - 1 - maxFramePlayers*6 - 2
+)
+
+// 快照通道直接调用这些方法，缺少时编译失败，而不是悄悄退回到给所有人广播完整快照
+var (
+	_ snapshotSource    = (*game.BallBattleLogic)(nil)
+	_ netcore.GameLogic = (*logic)(nil)
+	_ netcore.GameLogic = (*frameLogic)(nil)
+)
+
+// New 创建服务器，使用 netcore 封装。snapshotListen 非空时在该地址上开启快照通道（见 snapshotChannel）；
+// 为空时快照随框架的帧发送，世界可能超过一帧或使用地图时拒绝启动
+func New(listen, snapshotListen string, tickHz int, foodCount int, arenaHalf float32, rules game.Rules, seed int64, arena *game.ArenaMap) (*Server, error) {
+	// 创建游戏状态（seed 为 0 时使用当前时间，arena 为 nil 时没有障碍物）
+	state := game.NewState(arenaHalf, foodCount, rules, seed, arena)
+
 	// 创建游戏逻辑
-	logic := game.NewBallBattleLogic(state)
-	
+	ballLogic := game.NewBallBattleLogic(state)
+	var gameLogic netcore.GameLogic = &frameLogic{ballLogic}
+	var snapshots *snapshotChannel
+	if snapshotListen == "" {
+		// 地图几何只经快照通道发送
+		if arena != nil {
+			return nil, fmt.Errorf("a map needs the snapshot channel (-snapshot-listen)")
+		}
+		// 机器人和真人一起最多 max(255, BotCount) 个玩家
+		if n := ballLogic.MaxSnapshotSize(max(maxFramePlayers, rules.BotCount)); n > maxFrameSnapshot {
+			return nil, fmt.Errorf("snapshots may reach %d bytes, more than a broadcast frame holds (%d); use the snapshot channel (-snapshot-listen) or lower the food and cell limits", n, maxFrameSnapshot)
+		}
+	} else {
+		// 地图几何作为一个事件发送，事件不分片
+		mapMsg := ballLogic.MapMessage()
+		if len(mapMsg) > channel.MaxEventSize {
+			return nil, fmt.Errorf("map geometry too large: %d bytes (limit %d)", len(mapMsg), channel.MaxEventSize)
+		}
+		var err error
+		if snapshots, err = newSnapshotChannel(snapshotListen, ballLogic, mapMsg); err != nil {
+			return nil, err
+		}
+		gameLogic = &logic{BallBattleLogic: ballLogic, snapshots: snapshots}
+	}
+
 	// 使用 netcore.Server 处理所有网络层
-	netcoreSrv, err := netcore.NewServer(listen, tickHz, logic)
+	netcoreSrv, err := netcore.NewServer(listen, tickHz, gameLogic)
 	if err != nil {
+		if snapshots != nil {
+			snapshots.conn.Close()
+		}
 		return nil, err
 	}
-	
-	return &Server{netcore: netcoreSrv}, nil
+
+	return &Server{netcore: netcoreSrv, snapshots: snapshots}, nil
+}
+
+// frameLogic 在关闭快照通道时使用：快照随框架的帧广播给所有人。
+// 玩家吐出的食物不计入 MaxSnapshotSize，快照仍可能超过一帧，此时返回错误而不是发送截断的帧
+type frameLogic struct {
+	*game.BallBattleLogic
+}
+
+func (l *frameLogic) Snapshot(tick uint32) ([]byte, error) {
+	data, err := l.BallBattleLogic.Snapshot(tick)
+	if err == nil && len(data) > maxFrameSnapshot {
+		return nil, fmt.Errorf("snapshot of %d bytes does not fit in a broadcast frame (%d)", len(data), maxFrameSnapshot)
+	}
+	return data, err
+}
+
+// SnapshotLoop 接收快照通道上的订阅和确认，没有开启快照通道时立即返回
+func (s *Server) SnapshotLoop() {
+	if s.snapshots != nil {
+		s.snapshots.recvLoop()
+	}
 }
 
 // ListenLoop 接收循环
@@ -0,0 +1,321 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	"ballbattle/internal/channel"
+	"ballbattle/internal/fragment"
+	"ballbattle/internal/game"
+	"gameframework/pkg/proto"
+)
+
+// snapshotSource 是快照通道使用的逻辑钩子：按客户端生成（增量、只含兴趣范围的）快照，
+// 以及记录客户端确认收到的快照
+type snapshotSource interface {
+	SnapshotFor(pid uint16, tick uint32) ([]byte, error)
+	AckSnapshots(pid uint16, ack, ackBits uint32)
+}
+
+const (
+	// subscriberTimeout 是多久收不到客户端的订阅包后停止向它发送快照
+	subscriberTimeout = 5 * time.Second
+	// unackedInterval 是客户端确认第一个快照之前发送快照的最小间隔，
+	// 限制一个订阅包在得到确认前能引出的流量
+	unackedInterval = 250 * time.Millisecond
+	// eventResendInterval 是重发还没有确认的事件的间隔
+	eventResendInterval = 200 * time.Millisecond
+	// maxPendingEvents 限制每个玩家未确认的事件数，超过时重新开始该玩家的事件流，
+	// 不为长时间不确认的客户端无限累积公告
+	maxPendingEvents = 16
+)
+
+// snapshotChannel 在单独的 UDP 端口上给每个客户端发送它自己的快照。
+// 框架的 BroadcastLoop 只能把同一份 Snapshot(tick) 发给所有人，
+// 也不会把客户端 UDP 头部中的 ack/ackBits 交给游戏，所以按客户端的快照走这条通道：
+//
+//	服务器 → 客户端: [UDP 头部: Seq = uint16(tick), ACK = 0, ACKBits = 0] [快照分片（见 internal/fragment）]
+//	客户端 → 服务器: [UDP 头部: Seq, ACK = uint16(最新解码的快照 tick), ACKBits] [订阅包（见 internal/channel）]
+//
+// UDP 头部就是框架的头部（proto.WriteUDPHeader），ACKBits 的第 i 位表示 tick ACK-1-i 也已收到。
+// 只有框架登记过（OnJoin）、并经框架的主连接登记了令牌的玩家才能订阅：
+// 订阅包必须带着同一个令牌、来自同一个 IP，快照发往最近一次收到该玩家订阅包的地址。
+//
+// 只发一次的消息（地图几何、回合公告）作为事件发送（见 internal/channel），重发直到客户端在订阅包中确认
+type snapshotChannel struct {
+	conn   *net.UDPConn
+	source snapshotSource
+	mapMsg []byte // 每个事件流的第一个事件：game.EncodeMap 的结果
+
+	mu      sync.Mutex
+	players map[uint16]*peer // 框架登记的玩家
+	tick    uint32           // 最近发送的 tick，用来把 16 位的 ACK 还原成完整的 tick
+	streams uint32           // 最近分配的事件流编号
+	round   []byte           // 最近的回合公告，新的事件流紧跟在地图之后发送
+}
+
+// peer 是快照通道对一个玩家的记录
+type peer struct {
+	ip       net.IP // 框架主连接上的来源 IP，令牌登记之前为 nil
+	token    uint64
+	addr     *net.UDPAddr // 订阅地址，还没有订阅或订阅超时时为 nil
+	lastSeen time.Time    // 最近一次收到订阅包的时间
+	lastSent time.Time
+	acked    bool // 客户端确认过快照
+
+	stream    uint32          // 当前事件流
+	events    []channel.Event // 还没有确认的事件，按 Seq 排序
+	lastEvent uint32          // 当前流中最后分配的 Seq
+	eventSent time.Time       // 最近一次发送未确认事件的时间
+}
+
+func newSnapshotChannel(listen string, source snapshotSource, mapMsg []byte) (*snapshotChannel, error) {
+	addr, err := net.ResolveUDPAddr("udp", listen)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &snapshotChannel{
+		conn:    conn,
+		source:  source,
+		mapMsg:  mapMsg,
+		players: make(map[uint16]*peer),
+		// 服务器重启后的事件流编号不与之前的重复，客户端会把它们当作新的流
+		streams: uint32(time.Now().Unix()),
+	}, nil
+}
+
+func (c *snapshotChannel) join(pid uint16) {
+	c.mu.Lock()
+	p := &peer{}
+	c.restartEvents(p)
+	c.players[pid] = p
+	c.mu.Unlock()
+}
+
+// restartEvents 给 p 开始一个新的事件流：地图几何，以及最近的回合公告。调用时持有 c.mu
+func (c *snapshotChannel) restartEvents(p *peer) {
+	c.streams++
+	if c.streams == 0 {
+		c.streams++
+	}
+	p.stream, p.events, p.lastEvent, p.eventSent = c.streams, nil, 0, time.Time{}
+	c.queueEvent(p, c.mapMsg)
+	if c.round != nil {
+		c.queueEvent(p, c.round)
+	}
+}
+
+// queueEvent 在 p 的事件流中追加一个事件，下一次 send 时发送。调用时持有 c.mu
+func (c *snapshotChannel) queueEvent(p *peer, payload []byte) {
+	if len(p.events) >= maxPendingEvents {
+		// 新的事件流已经包含最新的回合公告，之前没有确认的公告都过时了
+		c.restartEvents(p)
+		return
+	}
+	p.lastEvent++
+	p.events = append(p.events, channel.Event{Stream: p.stream, Seq: p.lastEvent, Payload: payload})
+	p.eventSent = time.Time{}
+}
+
+// announce 把回合公告作为事件发给所有玩家
+func (c *snapshotChannel) announce(payload []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.round = payload
+	for _, p := range c.players {
+		c.queueEvent(p, payload)
+	}
+}
+
+// ackEvents 处理订阅包中的事件确认。调用时持有 c.mu
+func (c *snapshotChannel) ackEvents(p *peer, stream, ack uint32) {
+	if stream != p.stream {
+		// 客户端停在别的流上（例如迟到的旧事件让它切了回去）。当前流还有未确认的事件时
+		// 客户端迟早会切过来；否则它收不到当前流之后的事件，只能重新开始
+		if stream != 0 && len(p.events) == 0 {
+			c.restartEvents(p)
+		}
+		return
+	}
+	i := 0
+	for i < len(p.events) && p.events[i].Seq <= ack {
+		i++
+	}
+	p.events = p.events[i:]
+}
+
+func (c *snapshotChannel) leave(pid uint16) {
+	c.mu.Lock()
+	delete(c.players, pid)
+	c.mu.Unlock()
+}
+
+// register 记下 pid 经框架主连接登记的令牌和来源地址，之前的订阅作废
+func (c *snapshotChannel) register(pid uint16, addr *net.UDPAddr, token uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if p := c.players[pid]; p != nil && addr != nil {
+		p.ip, p.token = addr.IP, token
+		p.addr, p.acked = nil, false
+	}
+}
+
+// recvLoop 接收客户端的订阅和快照确认
+func (c *snapshotChannel) recvLoop() {
+	buf := make([]byte, 1500)
+	for {
+		n, addr, err := c.conn.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Printf("snapshot channel: %v", err)
+			continue
+		}
+		_, ack, ackBits, payload, err := proto.ReadUDPHeader(buf[:n])
+		if err != nil {
+			continue
+		}
+		sub, ok := channel.ParseSubscribe(payload)
+		if !ok {
+			continue
+		}
+
+		c.mu.Lock()
+		p := c.players[sub.PlayerID]
+		if p == nil || p.ip == nil || sub.Token != p.token || !addr.IP.Equal(p.ip) {
+			c.mu.Unlock()
+			continue
+		}
+		p.addr, p.lastSeen = addr, time.Now()
+		p.acked = p.acked || sub.HasAck
+		c.ackEvents(p, sub.Stream, sub.EventAck)
+		// ACK 只有 16 位：取不晚于最近发送的 tick 中低 16 位相同的那个
+		tick := c.tick - uint32(uint16(c.tick)-ack)
+		c.mu.Unlock()
+
+		if sub.HasAck {
+			c.source.AckSnapshots(sub.PlayerID, tick, ackBits)
+		}
+	}
+}
+
+// send 把 tick 的快照发给每个订阅者，并每 eventResendInterval 重发还没有确认的事件。
+// 超过 subscriberTimeout 没有订阅包的订阅者被移除，
+// 还没有确认过快照的订阅者最多每 unackedInterval 收到一个快照
+func (c *snapshotChannel) send(tick uint32) {
+	type target struct {
+		pid  uint16
+		addr *net.UDPAddr
+	}
+	type pending struct {
+		addr   *net.UDPAddr
+		events []channel.Event
+	}
+	var targets []target
+	var resend []pending
+	now := time.Now()
+	c.mu.Lock()
+	c.tick = tick
+	for pid, p := range c.players {
+		if p.addr == nil {
+			continue
+		}
+		if now.Sub(p.lastSeen) > subscriberTimeout {
+			p.addr, p.acked = nil, false
+			continue
+		}
+		if len(p.events) > 0 && now.Sub(p.eventSent) >= eventResendInterval {
+			p.eventSent = now
+			resend = append(resend, pending{p.addr, slices.Clone(p.events)})
+		}
+		if !p.acked && now.Sub(p.lastSent) < unackedInterval {
+			continue
+		}
+		p.lastSent = now
+		targets = append(targets, target{pid, p.addr})
+	}
+	c.mu.Unlock()
+
+	buf := &bytes.Buffer{}
+	for _, r := range resend {
+		for _, e := range r.events {
+			buf.Reset()
+			proto.WriteUDPHeader(buf, uint16(tick), 0, 0)
+			buf.Write(channel.AppendEvent(nil, e))
+			c.conn.WriteToUDP(buf.Bytes(), r.addr)
+		}
+	}
+	for _, t := range targets {
+		data, err := c.source.SnapshotFor(t.pid, tick)
+		if err != nil {
+			log.Printf("snapshot for player %d: %v", t.pid, err)
+			continue
+		}
+		frags, err := fragment.Split(tick, data, fragment.MaxPayload)
+		if err != nil {
+			log.Printf("snapshot for player %d: %v", t.pid, err)
+			continue
+		}
+		for _, f := range frags {
+			buf.Reset()
+			proto.WriteUDPHeader(buf, uint16(tick), 0, 0)
+			buf.Write(f)
+			c.conn.WriteToUDP(buf.Bytes(), t.addr)
+		}
+	}
+}
+
+// logic 把快照通道接到游戏逻辑上：记录框架登记的玩家和令牌，在每个 tick 之后发送快照和回合公告，
+// 并让框架广播的帧不再携带快照
+type logic struct {
+	*game.BallBattleLogic
+	snapshots *snapshotChannel
+}
+
+func (l *logic) OnJoin(pid uint16) {
+	l.BallBattleLogic.OnJoin(pid)
+	l.snapshots.join(pid)
+}
+
+func (l *logic) OnLeave(pid uint16) {
+	l.snapshots.leave(pid)
+	l.BallBattleLogic.OnLeave(pid)
+}
+
+// HandleReliableMessage 处理客户端经框架主连接登记的快照通道令牌，其余消息交给游戏逻辑。
+// peerID 是框架按来源地址找到的玩家，addr 是该玩家在主连接上的地址
+func (l *logic) HandleReliableMessage(peerID uint16, addr *net.UDPAddr, msgType byte, payload []byte) (handled bool, playerID int) {
+	if msgType != channel.MsgToken {
+		return l.BallBattleLogic.HandleReliableMessage(peerID, addr, msgType, payload)
+	}
+	if token, ok := channel.ParseToken(payload); ok {
+		l.snapshots.register(peerID, addr, token)
+	}
+	return true, int(peerID)
+}
+
+// Tick 在游戏逻辑的 Tick 之后发送快照；回合阶段变化时先把公告作为事件发给所有玩家
+func (l *logic) Tick(tick uint32, inputs map[uint16]uint32) {
+	before := l.Round()
+	l.BallBattleLogic.Tick(tick, inputs)
+	if after := l.Round(); after.Phase != before.Phase || after.Round != before.Round {
+		l.snapshots.announce(game.EncodeRoundEvent(after))
+	}
+	l.snapshots.send(tick)
+}
+
+// Snapshot 返回空快照：快照已经按客户端经快照通道发送，框架广播的帧只用来同步 tick，
+// 不再把整个世界发给每个客户端
+func (l *logic) Snapshot(tick uint32) ([]byte, error) {
+	return nil, nil
+}
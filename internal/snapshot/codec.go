@@ -0,0 +1,342 @@
+package snapshot
+
+import (
+	"io"
+	"math"
+	"slices"
+)
+
+// Quantization of entity records.
+const (
+	// posBits is the fixed-point precision of a coordinate across the arena.
+	posBits = 16
+	// sizeBits is the precision of a radius or food value on a log scale
+	// between sizeMin and sizeMax. Code 0 stands for exactly 0.
+	sizeBits = 12
+	sizeMin  = 0.05
+	sizeMax  = 1000
+)
+
+// Largest record of each entity type in bytes, for sizing buffers.
+const (
+	maxPlayerRecord  = (74 + 17 + EffectKinds*17 + 7) / 8
+	maxCellRecord    = (16 + 32 + 2*posBits + sizeBits + 7) / 8
+	maxFoodRecord    = (32 + 2*posBits + 2*sizeBits + 17 + 7) / 8
+	maxVirusRecord   = (32 + 2*posBits + sizeBits + 7) / 8
+	maxPowerUpRecord = (32 + 2 + 2*posBits + 7) / 8
+)
+
+// PosError is the largest error of a decoded coordinate in an arena of
+// half-size arenaHalf.
+func PosError(arenaHalf float32) float32 {
+	return arenaHalf / (1<<posBits - 1)
+}
+
+// SizeError is the largest relative error of a decoded radius or food value
+// between 0.05 and 1000.
+func SizeError() float64 {
+	return math.Pow(sizeMax/sizeMin, 0.5/(1<<sizeBits-2)) - 1
+}
+
+// codec packs entity records into bits: coordinates become fixed point
+// relative to the arena, radii and food values a log scale, and small
+// fields only take the bits they need. Every record is padded to a whole
+// byte so records can be compared and concatenated as bytes.
+type codec struct {
+	arenaHalf float32
+}
+
+func (c codec) pos(v float32) uint64 {
+	const top = 1<<posBits - 1
+	if c.arenaHalf <= 0 {
+		return 0
+	}
+	t := (float64(v) + float64(c.arenaHalf)) / (2 * float64(c.arenaHalf)) * top
+	switch {
+	case !(t > 0):
+		return 0
+	case t >= top:
+		return top
+	}
+	return uint64(t + 0.5)
+}
+
+func (c codec) unpos(q uint64) float32 {
+	return float32(float64(q)/(1<<posBits-1)*2*float64(c.arenaHalf) - float64(c.arenaHalf))
+}
+
+// Binary exponents of sizeMin and sizeMax.
+const (
+	sizeExpMin = -5
+	sizeExpMax = 9
+)
+
+// sizeBounds[i] is the smallest value encoded as code i+2: halfway, on the
+// log scale, between the values codes i+1 and i+2 decode to.
+var sizeBounds [1<<sizeBits - 2]float32
+
+// sizeCodes[e][m] is the code of the smallest float32 with binary exponent
+// sizeExpMin+e and top 8 mantissa bits m. The values sharing those bits
+// span less than two code steps, so size finds a value's code from here in
+// at most two comparisons instead of taking a logarithm.
+var sizeCodes [sizeExpMax - sizeExpMin + 1][256]uint16
+
+func init() {
+	for i := range sizeBounds {
+		t := (float64(i) + 0.5) / (1<<sizeBits - 2)
+		sizeBounds[i] = float32(sizeMin * math.Pow(sizeMax/sizeMin, t))
+	}
+	for e := range sizeCodes {
+		for m := range sizeCodes[e] {
+			lo := math.Float32frombits(uint32(e+sizeExpMin+127)<<23 | uint32(m)<<15)
+			i, found := slices.BinarySearch(sizeBounds[:], lo)
+			if found {
+				i++
+			}
+			sizeCodes[e][m] = uint16(1 + i)
+		}
+	}
+}
+
+// size maps v onto codes 1..2^sizeBits-1 logarithmically, clamping it to
+// sizeMin..sizeMax; 0 encodes 0.
+func size(v float32) uint64 {
+	const top = 1<<sizeBits - 1
+	if v <= 0 {
+		return 0
+	}
+	bits := math.Float32bits(v)
+	e := int(bits>>23) - 127
+	switch {
+	case e < sizeExpMin:
+		return 1
+	case e > sizeExpMax:
+		return top
+	}
+	code := uint64(sizeCodes[e-sizeExpMin][bits>>15&0xff])
+	for code < top && v >= sizeBounds[code-1] {
+		code++
+	}
+	return code
+}
+
+func unsize(q uint64) float32 {
+	if q == 0 {
+		return 0
+	}
+	t := float64(q-1) / (1<<sizeBits - 2)
+	return float32(sizeMin * math.Pow(sizeMax/sizeMin, t))
+}
+
+// appendPlayer appends p's record:
+//
+//	id(16) team(8) status(2) killedBy(16) diedAt(32)
+//	respawning(1) [respawnIn(16)]
+//	per effect: active(1) [ticksLeft(16)]
+func (c codec) appendPlayer(dst []byte, p *Player) []byte {
+	w := bitWriter{buf: dst}
+	w.write(uint64(p.ID), 16)
+	w.write(uint64(p.Team), 8)
+	w.write(uint64(p.Status), 2)
+	w.write(uint64(p.KilledBy), 16)
+	w.write(uint64(p.DiedAt), 32)
+	w.optional(uint64(p.RespawnIn), 16)
+	for _, left := range p.Effects {
+		w.optional(uint64(left), 16)
+	}
+	return w.flush()
+}
+
+func (c codec) readPlayer(r io.ByteReader) (Player, error) {
+	br := bitReader{r: r}
+	var p Player
+	p.ID = uint16(br.read(16))
+	p.Team = uint8(br.read(8))
+	p.Status = uint8(br.read(2))
+	p.KilledBy = uint16(br.read(16))
+	p.DiedAt = uint32(br.read(32))
+	p.RespawnIn = uint16(br.optional(16))
+	for k := range p.Effects {
+		p.Effects[k] = uint16(br.optional(16))
+	}
+	return p, br.err
+}
+
+// appendCell appends cell's record:
+//
+//	owner(16) id(32) x(16) y(16) radius(12)
+func (c codec) appendCell(dst []byte, cell *Cell) []byte {
+	w := bitWriter{buf: dst}
+	w.write(uint64(cell.Owner), 16)
+	w.write(uint64(cell.ID), 32)
+	w.write(c.pos(cell.X), posBits)
+	w.write(c.pos(cell.Y), posBits)
+	w.write(size(cell.Radius), sizeBits)
+	return w.flush()
+}
+
+func (c codec) readCell(r io.ByteReader) (Cell, error) {
+	br := bitReader{r: r}
+	var cell Cell
+	cell.Owner = uint16(br.read(16))
+	cell.ID = uint32(br.read(32))
+	cell.X = c.unpos(br.read(posBits))
+	cell.Y = c.unpos(br.read(posBits))
+	cell.Radius = unsize(br.read(sizeBits))
+	return cell, br.err
+}
+
+// appendFood appends f's record:
+//
+//	id(32) x(16) y(16) value(12) radius(12) ejected(1) [owner(16)]
+func (c codec) appendFood(dst []byte, f *Food) []byte {
+	w := bitWriter{buf: dst}
+	w.write(uint64(f.ID), 32)
+	w.write(c.pos(f.X), posBits)
+	w.write(c.pos(f.Y), posBits)
+	w.write(size(f.Value), sizeBits)
+	w.write(size(f.Radius), sizeBits)
+	if w.flag(f.Owner != NoOwner) {
+		w.write(uint64(f.Owner), 16)
+	}
+	return w.flush()
+}
+
+func (c codec) readFood(r io.ByteReader) (Food, error) {
+	br := bitReader{r: r}
+	var f Food
+	f.ID = uint32(br.read(32))
+	f.X = c.unpos(br.read(posBits))
+	f.Y = c.unpos(br.read(posBits))
+	f.Value = unsize(br.read(sizeBits))
+	f.Radius = unsize(br.read(sizeBits))
+	f.Owner = NoOwner
+	if br.read(1) == 1 {
+		f.Owner = uint16(br.read(16))
+	}
+	return f, br.err
+}
+
+// appendVirus appends v's record:
+//
+//	id(32) x(16) y(16) radius(12)
+func (c codec) appendVirus(dst []byte, v *Virus) []byte {
+	w := bitWriter{buf: dst}
+	w.write(uint64(v.ID), 32)
+	w.write(c.pos(v.X), posBits)
+	w.write(c.pos(v.Y), posBits)
+	w.write(size(v.Radius), sizeBits)
+	return w.flush()
+}
+
+func (c codec) readVirus(r io.ByteReader) (Virus, error) {
+	br := bitReader{r: r}
+	var v Virus
+	v.ID = uint32(br.read(32))
+	v.X = c.unpos(br.read(posBits))
+	v.Y = c.unpos(br.read(posBits))
+	v.Radius = unsize(br.read(sizeBits))
+	return v, br.err
+}
+
+// appendPowerUp appends pu's record:
+//
+//	id(32) kind(2) x(16) y(16)
+func (c codec) appendPowerUp(dst []byte, pu *PowerUp) []byte {
+	w := bitWriter{buf: dst}
+	w.write(uint64(pu.ID), 32)
+	w.write(uint64(pu.Kind), 2)
+	w.write(c.pos(pu.X), posBits)
+	w.write(c.pos(pu.Y), posBits)
+	return w.flush()
+}
+
+func (c codec) readPowerUp(r io.ByteReader) (PowerUp, error) {
+	br := bitReader{r: r}
+	var pu PowerUp
+	pu.ID = uint32(br.read(32))
+	pu.Kind = uint8(br.read(2))
+	pu.X = c.unpos(br.read(posBits))
+	pu.Y = c.unpos(br.read(posBits))
+	return pu, br.err
+}
+
+// bitWriter appends bits to buf, least significant bit first.
+type bitWriter struct {
+	buf  []byte
+	acc  uint64
+	bits uint
+}
+
+// write appends the low n bits of v. n is at most 32, so with fewer than 8
+// bits pending the accumulator cannot overflow.
+func (w *bitWriter) write(v uint64, n uint) {
+	w.acc |= (v & (1<<n - 1)) << w.bits
+	w.bits += n
+	for w.bits >= 8 {
+		w.buf = append(w.buf, byte(w.acc))
+		w.acc >>= 8
+		w.bits -= 8
+	}
+}
+
+// flag writes b as one bit and returns it.
+func (w *bitWriter) flag(b bool) bool {
+	if b {
+		w.write(1, 1)
+	} else {
+		w.write(0, 1)
+	}
+	return b
+}
+
+// optional writes a presence bit, then v in n bits only if it is not 0.
+func (w *bitWriter) optional(v uint64, n uint) {
+	if w.flag(v != 0) {
+		w.write(v, n)
+	}
+}
+
+// flush pads the last byte with zeros and returns the buffer.
+func (w *bitWriter) flush() []byte {
+	if w.bits > 0 {
+		w.buf = append(w.buf, byte(w.acc))
+		w.acc, w.bits = 0, 0
+	}
+	return w.buf
+}
+
+// bitReader reads bits written by bitWriter from a single record. The
+// first error sticks and later reads return 0.
+type bitReader struct {
+	r    io.ByteReader
+	acc  uint64
+	bits uint
+	err  error
+}
+
+func (br *bitReader) read(n uint) uint64 {
+	for br.bits < n && br.err == nil {
+		b, err := br.r.ReadByte()
+		if err != nil {
+			br.err = err
+			break
+		}
+		br.acc |= uint64(b) << br.bits
+		br.bits += 8
+	}
+	if br.err != nil {
+		return 0
+	}
+	v := br.acc & (1<<n - 1)
+	br.acc >>= n
+	br.bits -= n
+	return v
+}
+
+func (br *bitReader) optional(n uint) uint64 {
+	if br.read(1) == 0 {
+		return 0
+	}
+	return br.read(n)
+}
@@ -0,0 +1,385 @@
+package snapshot
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+const testArenaHalf = 100
+
+// testWorld returns a world with players players of cellsPer cells each,
+// foods pellets (every tenth ejected by player 1), a few viruses and
+// power-ups, all placed at random inside the arena.
+func testWorld(rng *rand.Rand, players, cellsPer, foods int) *World {
+	coord := func() float32 { return (rng.Float32()*2 - 1) * testArenaHalf }
+	w := &World{
+		ArenaHalf:  testArenaHalf,
+		Round:      Round{Phase: 1, Number: 3, Remaining: 5000, Winner: NoOwner},
+		TeamMasses: []float32{12.5, 40},
+	}
+	for i := 1; i <= players; i++ {
+		p := Player{ID: uint16(i), Team: uint8(i % 2), Status: uint8(i % 3), KilledBy: uint16(i + 1), DiedAt: uint32(i * 100)}
+		if i%4 == 0 {
+			p.RespawnIn = 90
+			p.Effects[i%EffectKinds] = 300
+		}
+		w.Players = append(w.Players, p)
+		for j := 0; j < cellsPer; j++ {
+			id := uint32(i*cellsPer + j)
+			w.Cells = append(w.Cells, Cell{Owner: p.ID, ID: id, X: coord(), Y: coord(), Radius: 0.5 + rng.Float32()*20})
+		}
+	}
+	for i := 1; i <= foods; i++ {
+		f := Food{ID: uint32(i), X: coord(), Y: coord(), Value: 0.05 + rng.Float32()*0.1, Radius: 0.2 + rng.Float32()*0.3, Owner: NoOwner}
+		if i%10 == 0 {
+			f.Owner = 1
+		}
+		w.Foods = append(w.Foods, f)
+	}
+	for i := 1; i <= 8; i++ {
+		w.Viruses = append(w.Viruses, Virus{ID: uint32(i), X: coord(), Y: coord(), Radius: 4})
+	}
+	for i := 1; i <= 4; i++ {
+		w.PowerUps = append(w.PowerUps, PowerUp{ID: uint32(i), Kind: uint8(i % 3), X: coord(), Y: coord()})
+	}
+	return w
+}
+
+// compareWorlds fails unless got is want within the quantization bounds.
+func compareWorlds(t *testing.T, want, got *World) {
+	t.Helper()
+	posErr := float64(PosError(want.ArenaHalf)) * 1.01 // float32 arithmetic adds a little
+	sizeErr := SizeError() * 1.01
+	pos := func(what string, id uint32, want, got float32) {
+		if d := math.Abs(float64(want - got)); d > posErr {
+			t.Fatalf("%s %d: coordinate %v decoded as %v, error %g above %g", what, id, want, got, d, posErr)
+		}
+	}
+	size := func(what string, id uint32, want, got float32) {
+		if d := math.Abs(float64(got/want - 1)); d > sizeErr {
+			t.Fatalf("%s %d: size %v decoded as %v, relative error %g above %g", what, id, want, got, d, sizeErr)
+		}
+	}
+	if got.Round != want.Round || got.ZoneRadius != want.ZoneRadius || len(got.TeamMasses) != len(want.TeamMasses) {
+		t.Fatalf("head decoded as %+v, want %+v", got, want)
+	}
+	if len(got.Players) != len(want.Players) || len(got.Cells) != len(want.Cells) || len(got.Foods) != len(want.Foods) ||
+		len(got.Viruses) != len(want.Viruses) || len(got.PowerUps) != len(want.PowerUps) {
+		t.Fatalf("entity counts differ: got %d/%d/%d/%d/%d, want %d/%d/%d/%d/%d",
+			len(got.Players), len(got.Cells), len(got.Foods), len(got.Viruses), len(got.PowerUps),
+			len(want.Players), len(want.Cells), len(want.Foods), len(want.Viruses), len(want.PowerUps))
+	}
+	for i, w := range want.Players {
+		if got.Players[i] != w {
+			t.Fatalf("player %d decoded as %+v, want %+v", w.ID, got.Players[i], w)
+		}
+	}
+	for i, w := range want.Cells {
+		g := got.Cells[i]
+		if g.ID != w.ID || g.Owner != w.Owner {
+			t.Fatalf("cell %d decoded as %+v", w.ID, g)
+		}
+		pos("cell", w.ID, w.X, g.X)
+		pos("cell", w.ID, w.Y, g.Y)
+		size("cell", w.ID, w.Radius, g.Radius)
+	}
+	for i, w := range want.Foods {
+		g := got.Foods[i]
+		if g.ID != w.ID || g.Owner != w.Owner {
+			t.Fatalf("food %d decoded as %+v", w.ID, g)
+		}
+		pos("food", w.ID, w.X, g.X)
+		pos("food", w.ID, w.Y, g.Y)
+		size("food", w.ID, w.Value, g.Value)
+		size("food", w.ID, w.Radius, g.Radius)
+	}
+	for i, w := range want.Viruses {
+		g := got.Viruses[i]
+		if g.ID != w.ID {
+			t.Fatalf("virus %d decoded as %+v", w.ID, g)
+		}
+		pos("virus", w.ID, w.X, g.X)
+		pos("virus", w.ID, w.Y, g.Y)
+		size("virus", w.ID, w.Radius, g.Radius)
+	}
+	for i, w := range want.PowerUps {
+		g := got.PowerUps[i]
+		if g.ID != w.ID || g.Kind != w.Kind {
+			t.Fatalf("power-up %d decoded as %+v", w.ID, g)
+		}
+		pos("power-up", w.ID, w.X, g.X)
+		pos("power-up", w.ID, w.Y, g.Y)
+	}
+}
+
+func TestQuantizationBounds(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, arenaHalf := range []float32{1, 100, 5000} {
+		c := codec{arenaHalf: arenaHalf}
+		bound := float64(PosError(arenaHalf)) * 1.01
+		for i := 0; i < 100000; i++ {
+			v := (rng.Float32()*2 - 1) * arenaHalf
+			if d := math.Abs(float64(c.unpos(c.pos(v)) - v)); d > bound {
+				t.Fatalf("arena %v: coordinate %v decoded with error %g above %g", arenaHalf, v, d, bound)
+			}
+		}
+	}
+	bound := SizeError() * 1.01
+	for i := 0; i < 100000; i++ {
+		// log-uniform over the whole range
+		v := float32(sizeMin * math.Pow(sizeMax/sizeMin, rng.Float64()))
+		if d := math.Abs(float64(unsize(size(v))/v - 1)); d > bound {
+			t.Fatalf("size %v decoded with relative error %g above %g", v, d, bound)
+		}
+	}
+}
+
+func TestQuantizationEdges(t *testing.T) {
+	c := codec{arenaHalf: testArenaHalf}
+	posErr := PosError(testArenaHalf)
+	for _, tc := range []struct {
+		in, want float32
+	}{
+		{0, 0},
+		{-testArenaHalf, -testArenaHalf},
+		{testArenaHalf, testArenaHalf},
+		{-testArenaHalf - 50, -testArenaHalf}, // outside the arena: clamped to the edge
+		{testArenaHalf + 50, testArenaHalf},
+	} {
+		if got := c.unpos(c.pos(tc.in)); math.Abs(float64(got-tc.want)) > float64(posErr) {
+			t.Errorf("coordinate %v decoded as %v, want %v", tc.in, got, tc.want)
+		}
+	}
+	if q := c.pos(testArenaHalf); q != 1<<posBits-1 {
+		t.Errorf("arena edge quantized to %d, want %d", q, 1<<posBits-1)
+	}
+
+	for _, tc := range []struct {
+		in, want float32
+	}{
+		{0, 0}, // exact: code 0 is reserved for it
+		{-3, 0},
+		{float32(math.Inf(-1)), 0},
+		{sizeMin, sizeMin},
+		{sizeMin / 10, sizeMin}, // below the scale: clamped up
+		{sizeMax, sizeMax},
+		{sizeMax * 5, sizeMax}, // above the scale: clamped down
+		{float32(math.Inf(1)), sizeMax},
+	} {
+		got := unsize(size(tc.in))
+		if tc.want == 0 {
+			if got != 0 {
+				t.Errorf("size %v decoded as %v, want exactly 0", tc.in, got)
+			}
+			continue
+		}
+		if d := math.Abs(float64(got/tc.want - 1)); d > SizeError()*1.01 {
+			t.Errorf("size %v decoded as %v, want %v", tc.in, got, tc.want)
+		}
+	}
+	if q := size(sizeMin / 10); q == 0 {
+		t.Errorf("positive size below the scale encoded as 0")
+	}
+}
+
+func TestVersionError(t *testing.T) {
+	data := NewFrame(1, testWorld(rand.New(rand.NewSource(1)), 2, 1, 10)).AppendFull(nil)
+	data[0] = Version + 1
+	_, err := NewDecoder().Decode(1, data)
+	var verr *VersionError
+	if !errors.As(err, &verr) {
+		t.Fatalf("Decode of version %d: got %v, want a VersionError", Version+1, err)
+	}
+	if verr.Got != Version+1 {
+		t.Fatalf("VersionError.Got = %d, want %d", verr.Got, Version+1)
+	}
+}
+
+func TestFullRoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, foods := range []int{0, 1, 500} {
+		w := testWorld(rng, 10, 3, foods)
+		f := NewFrame(7, w)
+		data := f.AppendFull(nil)
+		if len(data) != f.FullSize() {
+			t.Fatalf("FullSize %d, AppendFull wrote %d bytes", f.FullSize(), len(data))
+		}
+		if limit := MaxFullSize(10, 30, foods, 8, 4, 2); len(data) > limit {
+			t.Fatalf("AppendFull wrote %d bytes, MaxFullSize %d", len(data), limit)
+		}
+		got, err := NewDecoder().Decode(7, data)
+		if err != nil {
+			t.Fatalf("foods=%d: %v", foods, err)
+		}
+		compareWorlds(t, w, got)
+	}
+}
+
+// TestDeltaRoundTrip moves, adds and removes entities between ticks and
+// checks that a delta against an acknowledged baseline decodes to the same
+// world as a full snapshot, filtered or not.
+func TestDeltaRoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	w := testWorld(rng, 10, 3, 500)
+	for _, filter := range []bool{false, true} {
+		encode := func(tick uint32, w *World) *Frame {
+			f := NewFrame(tick, w)
+			if filter {
+				f = f.Within(1, w.Cells[0].X, w.Cells[0].Y, 60)
+			}
+			return f
+		}
+		dec := NewDecoder()
+		base := encode(1, w)
+		if _, err := dec.Decode(1, base.AppendFull(nil)); err != nil {
+			t.Fatal(err)
+		}
+		for tick := uint32(2); tick < 20; tick++ {
+			next := *w
+			next.Cells = append([]Cell(nil), w.Cells...)
+			for i := range next.Cells {
+				next.Cells[i].X = max(-testArenaHalf, min(testArenaHalf, next.Cells[i].X+rng.Float32()-0.5))
+			}
+			// drop some pellets and add new ones with higher IDs
+			next.Foods = append([]Food(nil), w.Foods[3:]...)
+			last := next.Foods[len(next.Foods)-1].ID
+			for i := uint32(1); i <= 3; i++ {
+				next.Foods = append(next.Foods, Food{ID: last + i, X: 1, Y: 2, Value: 0.05, Radius: 0.3, Owner: NoOwner})
+			}
+			next.Round.Remaining--
+			w = &next
+
+			f := encode(tick, w)
+			delta := f.AppendDelta(nil, base)
+			if delta[1] != KindDelta {
+				t.Fatalf("tick %d: kind %d", tick, delta[1])
+			}
+			got, err := dec.Decode(tick, delta)
+			if err != nil {
+				t.Fatalf("filter=%v tick %d: %v", filter, tick, err)
+			}
+			want, err := NewDecoder().Decode(tick, f.AppendFull(nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			compareWorlds(t, want, got)
+			if !filter {
+				compareWorlds(t, w, got)
+			}
+			base = f
+		}
+	}
+}
+
+// encodeFloat writes the entities the way snapshots were written before
+// quantization: float32 fields through binary.Write.
+func encodeFloat(w *World) []byte {
+	buf := &bytes.Buffer{}
+	binary.Write(buf, binary.LittleEndian, uint16(len(w.Players)))
+	for _, p := range w.Players {
+		binary.Write(buf, binary.LittleEndian, p.ID)
+		binary.Write(buf, binary.LittleEndian, p.Team)
+		binary.Write(buf, binary.LittleEndian, p.Status)
+		binary.Write(buf, binary.LittleEndian, p.KilledBy)
+		binary.Write(buf, binary.LittleEndian, p.DiedAt)
+		binary.Write(buf, binary.LittleEndian, p.RespawnIn)
+		for _, left := range p.Effects {
+			binary.Write(buf, binary.LittleEndian, left)
+		}
+	}
+	binary.Write(buf, binary.LittleEndian, uint16(len(w.Cells)))
+	for _, c := range w.Cells {
+		binary.Write(buf, binary.LittleEndian, c.Owner)
+		binary.Write(buf, binary.LittleEndian, c.ID)
+		binary.Write(buf, binary.LittleEndian, c.X)
+		binary.Write(buf, binary.LittleEndian, c.Y)
+		binary.Write(buf, binary.LittleEndian, c.Radius)
+	}
+	binary.Write(buf, binary.LittleEndian, uint16(len(w.Foods)))
+	for _, f := range w.Foods {
+		binary.Write(buf, binary.LittleEndian, f.ID)
+		binary.Write(buf, binary.LittleEndian, f.X)
+		binary.Write(buf, binary.LittleEndian, f.Y)
+		binary.Write(buf, binary.LittleEndian, f.Value)
+		binary.Write(buf, binary.LittleEndian, f.Radius)
+		binary.Write(buf, binary.LittleEndian, f.Owner)
+	}
+	binary.Write(buf, binary.LittleEndian, uint16(len(w.Viruses)))
+	for _, v := range w.Viruses {
+		binary.Write(buf, binary.LittleEndian, v.ID)
+		binary.Write(buf, binary.LittleEndian, v.X)
+		binary.Write(buf, binary.LittleEndian, v.Y)
+		binary.Write(buf, binary.LittleEndian, v.Radius)
+	}
+	binary.Write(buf, binary.LittleEndian, uint16(len(w.PowerUps)))
+	for _, pu := range w.PowerUps {
+		binary.Write(buf, binary.LittleEndian, pu.ID)
+		binary.Write(buf, binary.LittleEndian, pu.Kind)
+		binary.Write(buf, binary.LittleEndian, pu.X)
+		binary.Write(buf, binary.LittleEndian, pu.Y)
+	}
+	return buf.Bytes()
+}
+
+// BenchmarkEncode compares the float32 encoding snapshots used to have with
+// encoding a Frame and writing it as a full snapshot, at several food
+// counts with 10 players of 2 cells.
+func BenchmarkEncode(b *testing.B) {
+	for _, foods := range []int{120, 500, 2000} {
+		w := testWorld(rand.New(rand.NewSource(1)), 10, 2, foods)
+		b.Run(fmt.Sprintf("float/foods=%d", foods), func(b *testing.B) {
+			var n int
+			for i := 0; i < b.N; i++ {
+				n = len(encodeFloat(w))
+			}
+			b.ReportMetric(float64(n), "B/snapshot")
+		})
+		b.Run(fmt.Sprintf("packed/foods=%d", foods), func(b *testing.B) {
+			var n int
+			for i := 0; i < b.N; i++ {
+				n = len(NewFrame(uint32(i), w).AppendFull(nil))
+			}
+			b.ReportMetric(float64(n), "B/snapshot")
+		})
+	}
+}
+
+// BenchmarkDelta measures a delta against the previous tick after every
+// cell moved, the steady state of a running game.
+func BenchmarkDelta(b *testing.B) {
+	for _, foods := range []int{120, 500, 2000} {
+		b.Run(fmt.Sprintf("foods=%d", foods), func(b *testing.B) {
+			w := testWorld(rand.New(rand.NewSource(1)), 10, 2, foods)
+			base := NewFrame(0, w)
+			for i := range w.Cells {
+				w.Cells[i].X += 1
+			}
+			f := NewFrame(1, w)
+			var n int
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				n = len(f.AppendDelta(nil, base))
+			}
+			b.ReportMetric(float64(n), "B/snapshot")
+		})
+	}
+}
+
+func BenchmarkDecode(b *testing.B) {
+	for _, foods := range []int{120, 500, 2000} {
+		b.Run(fmt.Sprintf("foods=%d", foods), func(b *testing.B) {
+			data := NewFrame(1, testWorld(rand.New(rand.NewSource(1)), 10, 2, foods)).AppendFull(nil)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := NewDecoder().Decode(1, data); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
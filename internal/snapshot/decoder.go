@@ -0,0 +1,201 @@
+package snapshot
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// ErrNoBaseline is returned for a delta whose baseline the decoder no
+// longer (or never) had. The snapshot is not acknowledged, so the server
+// falls back to an older baseline or a full snapshot.
+var ErrNoBaseline = errors.New("snapshot baseline missing")
+
+// Decoder turns the snapshots of one server into Worlds. It keeps the last
+// HistoryLen decoded Worlds as delta baselines and tracks which ticks to
+// acknowledge.
+type Decoder struct {
+	history map[uint32]*World
+
+	mu      sync.Mutex
+	ack     uint32 // newest decoded tick
+	ackBits uint32 // bit i: tick ack-1-i was decoded too
+	hasAck  bool
+}
+
+func NewDecoder() *Decoder {
+	return &Decoder{history: make(map[uint32]*World)}
+}
+
+// Decode parses the snapshot sent at tick, applying a delta to its
+// baseline, and remembers the result as a baseline for later deltas. The
+// returned World must not be modified. Decode must not be called
+// concurrently with itself; Ack may be.
+func (d *Decoder) Decode(tick uint32, data []byte) (*World, error) {
+	r := bytes.NewReader(data)
+	var hdr [2]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, fmt.Errorf("header: %w", err)
+	}
+	if hdr[0] != Version {
+		return nil, &VersionError{Got: hdr[0]}
+	}
+	w := &World{}
+	base := w
+	delta := false
+	switch hdr[1] {
+	case KindFull:
+	case KindDelta:
+		var baseTick uint32
+		if err := binary.Read(r, binary.LittleEndian, &baseTick); err != nil {
+			return nil, fmt.Errorf("header: %w", err)
+		}
+		if base = d.history[baseTick]; base == nil {
+			return nil, fmt.Errorf("%w: tick %d", ErrNoBaseline, baseTick)
+		}
+		delta = true
+	default:
+		return nil, fmt.Errorf("unknown snapshot kind %d", hdr[1])
+	}
+
+	if err := readHead(r, w); err != nil {
+		return nil, fmt.Errorf("head: %w", err)
+	}
+	c := codec{arenaHalf: w.ArenaHalf}
+	var err error
+	if w.Players, err = readSection(r, delta, false, base.Players, c.readPlayer, func(p *Player) uint32 { return uint32(p.ID) }); err != nil {
+		return nil, fmt.Errorf("players: %w", err)
+	}
+	if w.Cells, err = readSection(r, delta, true, base.Cells, c.readCell, func(c *Cell) uint32 { return c.ID }); err != nil {
+		return nil, fmt.Errorf("cells: %w", err)
+	}
+	if w.Foods, err = readSection(r, delta, true, base.Foods, c.readFood, func(f *Food) uint32 { return f.ID }); err != nil {
+		return nil, fmt.Errorf("foods: %w", err)
+	}
+	if w.Viruses, err = readSection(r, delta, true, base.Viruses, c.readVirus, func(v *Virus) uint32 { return v.ID }); err != nil {
+		return nil, fmt.Errorf("viruses: %w", err)
+	}
+	if w.PowerUps, err = readSection(r, delta, true, base.PowerUps, c.readPowerUp, func(pu *PowerUp) uint32 { return pu.ID }); err != nil {
+		return nil, fmt.Errorf("power-ups: %w", err)
+	}
+
+	d.history[tick] = w
+	for t := range d.history {
+		if tick > t && tick-t >= HistoryLen {
+			delete(d.history, t)
+		}
+	}
+	d.acknowledge(tick)
+	return w, nil
+}
+
+// Ack returns the acknowledgement to send to the server: the newest decoded
+// tick and a bit for each of the 32 ticks before it (bit i = tick
+// ack-1-i), the same scheme as the reliable layer's ack/ackbits header. ok
+// is false until a snapshot was decoded.
+func (d *Decoder) Ack() (ack, bits uint32, ok bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.ack, d.ackBits, d.hasAck
+}
+
+func (d *Decoder) acknowledge(tick uint32) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	switch {
+	case !d.hasAck:
+		d.ack, d.ackBits, d.hasAck = tick, 0, true
+	case tick > d.ack:
+		n := tick - d.ack
+		d.ackBits <<= min(n, 32)
+		if n <= 32 {
+			d.ackBits |= 1 << (n - 1)
+		}
+		d.ack = tick
+	case d.ack-tick >= 1 && d.ack-tick <= 32:
+		d.ackBits |= 1 << (d.ack - tick - 1)
+	}
+}
+
+func readHead(r *bytes.Reader, w *World) error {
+	le := binary.LittleEndian
+	binary.Read(r, le, &w.ArenaHalf)
+	binary.Read(r, le, &w.Round.Phase)
+	binary.Read(r, le, &w.Round.Number)
+	binary.Read(r, le, &w.Round.Remaining)
+	binary.Read(r, le, &w.Round.Winner)
+	binary.Read(r, le, &w.Round.WinnerTeam)
+	binary.Read(r, le, &w.Round.WinnerMass)
+	binary.Read(r, le, &w.ZoneRadius)
+	binary.Read(r, le, &w.ZoneShrinkIn)
+	var teams uint8
+	if err := binary.Read(r, le, &teams); err != nil {
+		return err
+	}
+	w.TeamMasses = make([]float32, teams)
+	return binary.Read(r, le, w.TeamMasses)
+}
+
+// readSection reads one entity section. For a delta the removed IDs and
+// changed records are merged into base; all three lists are ascending by ID.
+func readSection[T any](r *bytes.Reader, delta, wide bool, base []T, read func(io.ByteReader) (T, error), id func(*T) uint32) ([]T, error) {
+	var removed []uint32
+	if delta {
+		var n uint16
+		if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+			return nil, err
+		}
+		removed = make([]uint32, n)
+		for i := range removed {
+			if wide {
+				binary.Read(r, binary.LittleEndian, &removed[i])
+				continue
+			}
+			var pid uint16
+			if err := binary.Read(r, binary.LittleEndian, &pid); err != nil {
+				return nil, err
+			}
+			removed[i] = uint32(pid)
+		}
+	}
+	var n uint16
+	if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+		return nil, err
+	}
+	changed := make([]T, n)
+	for i := range changed {
+		var err error
+		if changed[i], err = read(r); err != nil {
+			return nil, err
+		}
+	}
+	if !delta {
+		return changed, nil
+	}
+
+	out := make([]T, 0, max(len(base)+len(changed)-len(removed), 0))
+	j, k := 0, 0
+	for i := range base {
+		bid := id(&base[i])
+		for k < len(changed) && id(&changed[k]) < bid {
+			out = append(out, changed[k])
+			k++
+		}
+		if k < len(changed) && id(&changed[k]) == bid {
+			out = append(out, changed[k])
+			k++
+			continue
+		}
+		for j < len(removed) && removed[j] < bid {
+			j++
+		}
+		if j < len(removed) && removed[j] == bid {
+			continue
+		}
+		out = append(out, base[i])
+	}
+	return append(out, changed[k:]...), nil
+}
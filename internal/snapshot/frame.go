@@ -0,0 +1,343 @@
+package snapshot
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+)
+
+// Entity sections of a snapshot, in wire order.
+const (
+	sectionPlayers = iota
+	sectionCells
+	sectionFoods
+	sectionViruses
+	sectionPowerUps
+	sectionCount
+)
+
+// records holds the encoded entities of one type in a tick, back to back
+// in one buffer: record i belongs to ids[i] and ends at end[i].
+type records struct {
+	ids  []uint32 // ascending
+	end  []int
+	data []byte
+	at   []extent // where each of ids is, for interest filtering
+}
+
+// extent is where an entity sits, for interest filtering.
+type extent struct {
+	x, y, r float32
+	owner   uint16 // owning player of cells, else NoOwner
+}
+
+func newRecords(n, maxRecord int) *records {
+	return &records{
+		ids:  make([]uint32, 0, n),
+		end:  make([]int, 0, n),
+		data: make([]byte, 0, n*maxRecord),
+		at:   make([]extent, 0, n),
+	}
+}
+
+// add records the entity whose record was just appended to r.data.
+func (r *records) add(id uint32, at extent) {
+	r.ids = append(r.ids, id)
+	r.end = append(r.end, len(r.data))
+	r.at = append(r.at, at)
+}
+
+func (r *records) rec(i int) []byte {
+	start := 0
+	if i > 0 {
+		start = r.end[i-1]
+	}
+	return r.data[start:r.end[i]]
+}
+
+// section is one entity section of a Frame. Frames filtered for one
+// client share records with the full frame and only list the indices they
+// keep.
+type section struct {
+	*records
+	keep []int32 // indices into records, ascending; nil keeps all
+	wide bool    // IDs are uint32 on the wire (uint16 otherwise)
+}
+
+func (s *section) len() int {
+	if s.keep == nil {
+		return len(s.ids)
+	}
+	return len(s.keep)
+}
+
+// index maps the k-th entity of s to its index in s.records.
+func (s *section) index(k int) int {
+	if s.keep == nil {
+		return k
+	}
+	return int(s.keep[k])
+}
+
+// Frame is a World encoded once per tick, from which the full snapshot or a
+// delta against an earlier Frame is written for every client.
+type Frame struct {
+	tick     uint32
+	head     []byte // arena, round, zone and team masses, always sent in full
+	sections [sectionCount]section
+}
+
+// NewFrame encodes w, whose entity slices must be sorted by ID.
+func NewFrame(tick uint32, w *World) *Frame {
+	f := &Frame{tick: tick}
+	f.head = appendHead(nil, w)
+	c := codec{arenaHalf: w.ArenaHalf}
+
+	players := newRecords(len(w.Players), maxPlayerRecord)
+	for i := range w.Players {
+		p := &w.Players[i]
+		players.data = c.appendPlayer(players.data, p)
+		players.add(uint32(p.ID), extent{})
+	}
+	cells := newRecords(len(w.Cells), maxCellRecord)
+	for i := range w.Cells {
+		cell := &w.Cells[i]
+		cells.data = c.appendCell(cells.data, cell)
+		cells.add(cell.ID, extent{cell.X, cell.Y, cell.Radius, cell.Owner})
+	}
+	foods := newRecords(len(w.Foods), maxFoodRecord)
+	for i := range w.Foods {
+		fd := &w.Foods[i]
+		foods.data = c.appendFood(foods.data, fd)
+		foods.add(fd.ID, extent{fd.X, fd.Y, fd.Radius, NoOwner})
+	}
+	viruses := newRecords(len(w.Viruses), maxVirusRecord)
+	for i := range w.Viruses {
+		v := &w.Viruses[i]
+		viruses.data = c.appendVirus(viruses.data, v)
+		viruses.add(v.ID, extent{v.X, v.Y, v.Radius, NoOwner})
+	}
+	powerUps := newRecords(len(w.PowerUps), maxPowerUpRecord)
+	for i := range w.PowerUps {
+		pu := &w.PowerUps[i]
+		powerUps.data = c.appendPowerUp(powerUps.data, pu)
+		powerUps.add(pu.ID, extent{pu.X, pu.Y, 0, NoOwner})
+	}
+
+	f.sections = [sectionCount]section{
+		sectionPlayers:  {records: players},
+		sectionCells:    {records: cells, wide: true},
+		sectionFoods:    {records: foods, wide: true},
+		sectionViruses:  {records: viruses, wide: true},
+		sectionPowerUps: {records: powerUps, wide: true},
+	}
+	return f
+}
+
+// appendHead appends the arena, round, zone and team masses of w.
+func appendHead(dst []byte, w *World) []byte {
+	le := binary.LittleEndian
+	dst = le.AppendUint32(dst, math.Float32bits(w.ArenaHalf))
+	dst = append(dst, w.Round.Phase)
+	dst = le.AppendUint32(dst, w.Round.Number)
+	dst = le.AppendUint32(dst, w.Round.Remaining)
+	dst = le.AppendUint16(dst, w.Round.Winner)
+	dst = append(dst, w.Round.WinnerTeam)
+	dst = le.AppendUint32(dst, math.Float32bits(w.Round.WinnerMass))
+	dst = le.AppendUint32(dst, math.Float32bits(w.ZoneRadius))
+	dst = le.AppendUint32(dst, w.ZoneShrinkIn)
+	dst = append(dst, uint8(len(w.TeamMasses)))
+	for _, m := range w.TeamMasses {
+		dst = le.AppendUint32(dst, math.Float32bits(m))
+	}
+	return dst
+}
+
+// Tick returns the tick f was encoded at.
+func (f *Frame) Tick() uint32 {
+	return f.tick
+}
+
+// Within returns the part of f that player pid is interested in: every
+// player record, pid's own cells, and the other entities that reach into
+// the circle of the given radius around (x, y). The result shares records
+// with f and cannot be filtered again.
+func (f *Frame) Within(pid uint16, x, y, radius float32) *Frame {
+	out := &Frame{tick: f.tick, head: f.head, sections: f.sections}
+	for i := range out.sections {
+		if i == sectionPlayers {
+			continue
+		}
+		s := &out.sections[i]
+		keep := make([]int32, 0, len(s.ids))
+		for j, at := range s.at {
+			if (i == sectionCells && at.owner == pid) ||
+				math.Hypot(float64(at.x-x), float64(at.y-y)) <= float64(radius+at.r) {
+				keep = append(keep, int32(j))
+			}
+		}
+		s.keep = keep
+	}
+	return out
+}
+
+// AppendFull appends f as a KindFull snapshot.
+func (f *Frame) AppendFull(dst []byte) []byte {
+	dst = append(dst, Version, KindFull)
+	dst = append(dst, f.head...)
+	for i := range f.sections {
+		s := &f.sections[i]
+		dst = binary.LittleEndian.AppendUint16(dst, uint16(s.len()))
+		if s.keep == nil {
+			dst = append(dst, s.data...)
+			continue
+		}
+		for _, j := range s.keep {
+			dst = append(dst, s.rec(int(j))...)
+		}
+	}
+	return dst
+}
+
+// FullSize is the length AppendFull adds.
+func (f *Frame) FullSize() int {
+	n := 2 + len(f.head)
+	for i := range f.sections {
+		s := &f.sections[i]
+		n += 2
+		if s.keep == nil {
+			n += len(s.data)
+			continue
+		}
+		for _, j := range s.keep {
+			n += len(s.rec(int(j)))
+		}
+	}
+	return n
+}
+
+// MaxFullSize is the largest full snapshot of a world with at most the
+// given number of each entity and teams team masses.
+func MaxFullSize(players, cells, foods, viruses, powerUps, teams int) int {
+	head := len(appendHead(nil, &World{TeamMasses: make([]float32, teams)}))
+	return 2 + head + 2*sectionCount +
+		players*maxPlayerRecord + cells*maxCellRecord + foods*maxFoodRecord +
+		viruses*maxVirusRecord + powerUps*maxPowerUpRecord
+}
+
+// AppendDelta appends f as a KindDelta snapshot against base: every section
+// lists the IDs that disappeared, then the records that are new or changed.
+func (f *Frame) AppendDelta(dst []byte, base *Frame) []byte {
+	le := binary.LittleEndian
+	dst = append(dst, Version, KindDelta)
+	dst = le.AppendUint32(dst, base.tick)
+	dst = append(dst, f.head...)
+	for i := range f.sections {
+		s, old := &f.sections[i], &base.sections[i]
+
+		// both ID lists are ascending: walk them together, once for the
+		// removed IDs and once for the changed records, and fill in each
+		// count afterwards
+		at := len(dst)
+		dst = append(dst, 0, 0)
+		removed := 0
+		j := 0
+		for k := range s.len() {
+			id := s.ids[s.index(k)]
+			for ; j < old.len() && old.ids[old.index(j)] < id; j++ {
+				dst = appendID(dst, old.ids[old.index(j)], s.wide)
+				removed++
+			}
+			if j < old.len() && old.ids[old.index(j)] == id {
+				j++
+			}
+		}
+		for ; j < old.len(); j++ {
+			dst = appendID(dst, old.ids[old.index(j)], s.wide)
+			removed++
+		}
+		le.PutUint16(dst[at:], uint16(removed))
+
+		at = len(dst)
+		dst = append(dst, 0, 0)
+		changed := 0
+		j = 0
+		for k := range s.len() {
+			idx := s.index(k)
+			id := s.ids[idx]
+			for j < old.len() && old.ids[old.index(j)] < id {
+				j++
+			}
+			rec := s.rec(idx)
+			if j < old.len() && old.ids[old.index(j)] == id {
+				same := bytes.Equal(rec, old.rec(old.index(j)))
+				j++
+				if same {
+					continue
+				}
+			}
+			dst = append(dst, rec...)
+			changed++
+		}
+		le.PutUint16(dst[at:], uint16(changed))
+	}
+	return dst
+}
+
+func appendID(dst []byte, id uint32, wide bool) []byte {
+	if wide {
+		return binary.LittleEndian.AppendUint32(dst, id)
+	}
+	return binary.LittleEndian.AppendUint16(dst, uint16(id))
+}
+
+// History is what the server remembers about the snapshots sent to one
+// client: the frames of the last HistoryLen ticks and which of them the
+// client acknowledged.
+type History struct {
+	sent   map[uint32]*Frame // tick -> frame sent at that tick
+	acked  uint32            // newest acknowledged tick still in sent
+	hasAck bool
+}
+
+func NewHistory() *History {
+	return &History{sent: make(map[uint32]*Frame)}
+}
+
+// Ack records the client's acknowledgement of tick ack and of the 32 ticks
+// before it flagged in bits (bit i = tick ack-1-i), the same scheme as the
+// reliable layer's ack/ackbits header.
+func (h *History) Ack(ack, bits uint32) {
+	for i := uint32(0); i <= 32; i++ {
+		if i > 0 && bits&(1<<(i-1)) == 0 {
+			continue
+		}
+		t := ack - i
+		if _, ok := h.sent[t]; ok && (!h.hasAck || t > h.acked) {
+			h.acked, h.hasAck = t, true
+		}
+	}
+}
+
+// Baseline returns the frame the next delta can be built against, or nil
+// when the client has not acknowledged anything still in the history.
+func (h *History) Baseline() *Frame {
+	if !h.hasAck {
+		return nil
+	}
+	return h.sent[h.acked]
+}
+
+// Record remembers f as sent and forgets frames that fell out of the
+// history window.
+func (h *History) Record(f *Frame) {
+	h.sent[f.tick] = f
+	for t := range h.sent {
+		if f.tick-t >= HistoryLen {
+			delete(h.sent, t)
+		}
+	}
+	if h.hasAck && h.sent[h.acked] == nil {
+		h.hasAck = false
+	}
+}
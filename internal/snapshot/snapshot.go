@@ -0,0 +1,138 @@
+// Package snapshot is the wire format of world snapshots, shared by the
+// server and the client so the layout is written down exactly once.
+//
+// Every snapshot starts with a header:
+//
+//	version(uint8) kind(uint8) [baseTick(uint32), deltas only]
+//
+// followed by the head, sent in full every time:
+//
+//	arenaHalf(float32)
+//	round: phase(uint8), number(uint32), remaining(uint32), winner(uint16), winnerTeam(uint8), winnerMass(float32)
+//	zone: radius(float32), shrinkIn(uint32)
+//	uint8 teamCount, [mass(float32)]*T
+//
+// and then five entity sections in the order players, cells, foods,
+// viruses, power-ups. In a full snapshot each section is a uint16 count
+// and that many records. In a delta each section first lists the IDs
+// removed since the baseline (uint16 count, then uint16 player IDs or
+// uint32 IDs), then the new or changed records (uint16 count, records).
+// Records are bit-packed by the codec in codec.go and listed by ascending
+// ID.
+//
+// Version is bumped on every incompatible change. Decoders refuse any other
+// version with a *VersionError instead of misreading the payload.
+package snapshot
+
+import "fmt"
+
+// Version is the snapshot format this package reads and writes.
+const Version byte = 1
+
+// Snapshot kinds, the second header byte.
+const (
+	// KindFull carries every entity.
+	KindFull byte = 0
+	// KindDelta carries only the entities added, changed or removed since
+	// a baseline snapshot the client has acknowledged.
+	KindDelta byte = 1
+)
+
+// HistoryLen is how many ticks of snapshots both sides keep as possible
+// baselines. A client whose newest acknowledgement is older gets a full
+// snapshot again.
+const HistoryLen = 64
+
+// EffectKinds is the number of power-up effects a Player record carries.
+const EffectKinds = 3
+
+// World is one decoded snapshot. Entity slices are sorted by ID. Worlds
+// returned by Decoder share slices with the decoder's history and must not
+// be modified.
+type World struct {
+	ArenaHalf float32
+	Round     Round
+	// ZoneRadius is the battle-royale safe radius, 0 without a zone, and
+	// ZoneShrinkIn the ticks until it next starts shrinking.
+	ZoneRadius   float32
+	ZoneShrinkIn uint32
+	// TeamMasses is the summed mass of every team, indexed by team - 1.
+	TeamMasses []float32
+
+	Players  []Player
+	Cells    []Cell
+	Foods    []Food
+	Viruses  []Virus
+	PowerUps []PowerUp
+}
+
+// Round is the round clock.
+type Round struct {
+	Phase      uint8
+	Number     uint32
+	Remaining  uint32
+	Winner     uint16
+	WinnerTeam uint8
+	WinnerMass float32
+}
+
+// Player is a player's life-cycle state; its cells are sent separately.
+type Player struct {
+	ID        uint16
+	Team      uint8
+	Status    uint8
+	KilledBy  uint16
+	DiedAt    uint32
+	RespawnIn uint16
+	// Effects holds the ticks left on each power-up effect.
+	Effects [EffectKinds]uint16
+}
+
+// Cell is a single ball of player Owner.
+type Cell struct {
+	Owner  uint16
+	ID     uint32
+	X      float32
+	Y      float32
+	Radius float32
+}
+
+// Food is a pellet. Owner is the player that ejected it, or 0xFFFF for
+// world food.
+type Food struct {
+	ID     uint32
+	X      float32
+	Y      float32
+	Value  float32
+	Radius float32
+	Owner  uint16
+}
+
+// NoOwner is the Owner of world-spawned food.
+const NoOwner uint16 = 0xFFFF
+
+// Virus is a virus.
+type Virus struct {
+	ID     uint32
+	X      float32
+	Y      float32
+	Radius float32
+}
+
+// PowerUp is a pickup lying in the arena.
+type PowerUp struct {
+	ID   uint32
+	Kind uint8
+	X    float32
+	Y    float32
+}
+
+// VersionError reports a snapshot written in a format version this package
+// does not understand.
+type VersionError struct {
+	Got byte
+}
+
+func (e *VersionError) Error() string {
+	return fmt.Sprintf("snapshot format version %d, expected %d", e.Got, Version)
+}